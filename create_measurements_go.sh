@@ -0,0 +1,25 @@
+#!/bin/sh
+#
+#  Copyright 2023 The original authors
+#
+#  Licensed under the Apache License, Version 2.0 (the "License");
+#  you may not use this file except in compliance with the License.
+#  You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  Unless required by applicable law or agreed to in writing, software
+#  distributed under the License is distributed on an "AS IS" BASIS,
+#  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+#  See the License for the specific language governing permissions and
+#  limitations under the License.
+#
+
+set -e
+
+# Go port of create_measurements.sh that does not need a JVM, see
+# src/main/go/onebrc/cmd/createmeasurements for options, e.g.:
+# ./create_measurements_go.sh 1000000000
+# ./create_measurements_go.sh -keys 10000 -stddev 7 -o measurements3.txt 1000000000
+go -C src/main/go/onebrc build -o ../../../../target/createmeasurements ./cmd/createmeasurements
+target/createmeasurements "$@"
@@ -2,56 +2,181 @@ package main
 
 import (
 	"bytes"
-	"fmt"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
 	"log"
-	"math"
+	"math/bits"
 	"os"
+	"path/filepath"
 	"runtime"
-	"sort"
+	"strings"
 	"sync"
 	"syscall"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/filter"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/groupby"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/output"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/profile"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
 )
 
-type measurement struct {
-	min, max, sum, count int64
-}
+type measurement = stats.Aggregate
+
+// fields are statistics to print, extra statistics are collected only if
+// they are needed, see stats.Fields.Extended.
+var fields = stats.DefaultFields
+
+// where selects records to aggregate, nil selects all of them.
+var where *filter.Filter
+
+var (
+	windowSizeMB = flag.Int("window", 64, "window size in MB used to read from stdin, pipes and .gz files")
+	stateFile    = flag.String("state", "", "persist results to `file` and process only data appended since the previous run")
+	validate     = flag.Bool("validate", false, "report each malformed record and fail if there is any")
+	skipInvalid  = flag.Bool("skip-invalid", false, "skip and count malformed records instead of assuming valid input")
+	histogram    = flag.Bool("histogram", false, "keep exact per station histograms, percentiles of -stats become exact")
+	histogramOut = flag.String("histogram-out", "", "write histogram buckets to `file` as JSON (.json) or CSV (.csv), implies -histogram")
+	whereExpr    = flag.String("where", "", "aggregate only records matching `expression`, e.g. 'station ~ \"^San\" and temp < 0'")
+)
 
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalf("Missing measurements filename")
+	var profiles profile.Config
+	profiles.RegisterFlags(flag.CommandLine)
+	flag.Var(&fields, "stats", stats.FieldsUsage)
+	var outputs output.Config
+	outputs.RegisterFlags(flag.CommandLine)
+	var groups groupby.Config
+	groups.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("Missing measurements filename, use - for stdin")
+	}
+	if *windowSizeMB <= 0 {
+		log.Fatalf("Invalid window size: %d", *windowSizeMB)
+	}
+	if err := outputs.Check(); err != nil {
+		log.Fatalf("Output: %v", err)
+	}
+	if err := groups.Load(); err != nil {
+		log.Fatalf("Group by: %v", err)
+	}
+	if *whereExpr != "" {
+		var err error
+		where, err = filter.Parse(*whereExpr)
+		if err != nil {
+			log.Fatalf("Where: %v", err)
+		}
+	}
+	if *histogramOut != "" {
+		if ext := filepath.Ext(*histogramOut); ext != ".json" && ext != ".csv" {
+			log.Fatalf("Unknown histogram format: %q", ext)
+		}
+		*histogram = true
+	}
+	if fields.Histogram() {
+		*histogram = true
+	}
+	if *stateFile != "" && (*validate || *skipInvalid) {
+		log.Fatalf("State is not supported with -validate and -skip-invalid")
 	}
 
-	measurements := processFile(os.Args[1])
+	defer profiles.Start()()
 
-	ids := make([]string, 0, len(measurements))
-	for id := range measurements {
-		ids = append(ids, id)
+	var measurements map[string]*measurement
+	if *validate || *skipInvalid {
+		measurements = checkFile(flag.Arg(0))
+	} else {
+		measurements = processFile(flag.Arg(0))
 	}
-	sort.Strings(ids)
+	measurements = groups.Apply(measurements)
 
-	fmt.Print("{")
-	for i, id := range ids {
-		if i > 0 {
-			fmt.Print(", ")
+	if err := outputs.Write(measurements, fields); err != nil {
+		log.Fatalf("Output: %v", err)
+	}
+	if *histogramOut != "" {
+		if err := writeHistograms(*histogramOut, measurements); err != nil {
+			log.Fatalf("Histogram: %v", err)
 		}
-		m := measurements[id]
-		fmt.Printf("%s=%.1f/%.1f/%.1f", id, round(float64(m.min)/10.0), round(float64(m.sum)/10.0/float64(m.count)), round(float64(m.max)/10.0))
 	}
-	fmt.Println("}")
 }
 
-func processFile(filename string) map[string]*measurement {
-	f, err := os.Open(filename)
+// newMeasurement returns an empty measurement that collects statistics
+// needed by -stats and -histogram flags.
+func newMeasurement() *measurement {
+	m := fields.NewAggregate()
+	if *histogram && m.Histogram == nil {
+		m.Histogram = new(stats.Histogram)
+	}
+	return m
+}
+
+func writeHistograms(filename string, measurements map[string]*measurement) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Ext(filename) == ".json" {
+		err = stats.WriteHistogramsJSON(f, measurements)
+	} else {
+		err = stats.WriteHistogramsCSV(f, measurements)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// openFile opens the file, - for stdin, and returns it with a reader of its
+// records that decompresses gzip compressed (.gz) files.
+// The returned function closes both.
+func openFile(filename string) (*os.File, io.Reader, func()) {
+	f := os.Stdin
+	if filename != "-" {
+		var err error
+		f, err = os.Open(filename)
+		if err != nil {
+			log.Fatalf("Open: %v", err)
+		}
+	}
+	if !strings.HasSuffix(filename, ".gz") {
+		return f, f, func() { f.Close() }
+	}
+
+	gr, err := gzip.NewReader(f)
 	if err != nil {
-		log.Fatalf("Open: %v", err)
+		log.Fatalf("Gzip: %v", err)
 	}
-	defer f.Close()
+	return f, gr, func() { gr.Close(); f.Close() }
+}
+
+// processFile mmaps regular files and streams everything else,
+// i.e. stdin (-), pipes and gzip compressed (.gz) files.
+// Only mmapped files can be processed incrementally with -state.
+func processFile(filename string) map[string]*measurement {
+	f, r, closeFile := openFile(filename)
+	defer closeFile()
 
 	fi, err := f.Stat()
 	if err != nil {
 		log.Fatalf("Stat: %v", err)
 	}
 
+	if !fi.Mode().IsRegular() || strings.HasSuffix(filename, ".gz") {
+		if *stateFile != "" {
+			log.Fatalf("State is not supported for stdin, pipes and .gz files")
+		}
+		measurements, err := processReader(r, *windowSizeMB*1024*1024)
+		if err != nil {
+			log.Fatalf("Read: %v", err)
+		}
+		return measurements
+	}
+
 	size := fi.Size()
 	if size <= 0 || size != int64(int(size)) {
 		log.Fatalf("Invalid file size: %d", size)
@@ -68,145 +193,272 @@ func processFile(filename string) map[string]*measurement {
 		}
 	}()
 
+	if *stateFile != "" {
+		return processIncremental(data, *stateFile)
+	}
 	return process(data)
 }
 
-func process(data []byte) map[string]*measurement {
-	nChunks := runtime.NumCPU()
+// checkFile processes file without assuming valid input, see stats.Check.
+func checkFile(filename string) map[string]*measurement {
+	_, r, closeFile := openFile(filename)
+	defer closeFile()
 
-	chunkSize := len(data) / nChunks
-	if chunkSize == 0 {
-		chunkSize = len(data)
+	var keep func(name []byte, temp int64) bool
+	if where != nil {
+		keep = where.Match
 	}
 
-	chunks := make([]int, 0, nChunks)
-	offset := 0
-	for offset < len(data) {
-		offset += chunkSize
-		if offset >= len(data) {
-			chunks = append(chunks, len(data))
-			break
+	invalid := 0
+	measurements, err := stats.CheckWhere(r, newMeasurement, keep, func(ir *stats.InvalidRecord) {
+		invalid++
+		if *validate {
+			log.Printf("%s: %v", filename, ir)
 		}
+	})
+	if err != nil {
+		log.Fatalf("Read: %v", err)
+	}
 
-		nlPos := bytes.IndexByte(data[offset:], '\n')
-		if nlPos == -1 {
-			chunks = append(chunks, len(data))
-			break
-		} else {
-			offset += nlPos + 1
-			chunks = append(chunks, offset)
+	if invalid > 0 {
+		if *validate {
+			log.Fatalf("Found %d invalid records", invalid)
+		}
+		log.Printf("Skipped %d invalid records", invalid)
+	}
+	return measurements
+}
+
+var errRecordTooLong = errors.New("record does not fit into window")
+
+// processReader reads r in windows of windowSize bytes and processes each
+// window's complete records with process. Incomplete record at the end of a
+// window is carried over to the next one, so memory use is bounded by
+// windowSize and does not depend on the input size.
+func processReader(r io.Reader, windowSize int) (map[string]*measurement, error) {
+	measurements := make(map[string]*measurement)
+
+	window := make([]byte, windowSize)
+	carry := 0
+	for {
+		n, err := io.ReadFull(r, window[carry:])
+		n += carry
+
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return nil, err
+		}
+
+		end := n
+		if !eof {
+			end = bytes.LastIndexByte(window[:n], '\n') + 1
+			if end == 0 {
+				return nil, errRecordTooLong
+			}
+		}
+
+		if end > 0 {
+			// process does not retain data, see processChunk
+			stats.Merge(measurements, process(window[:end]))
+		}
+
+		if eof {
+			return measurements, nil
 		}
+		carry = copy(window, window[end:n])
 	}
+}
+
+func process(data []byte) map[string]*measurement {
+	return processInChunks(data, runtime.NumCPU())
+}
+
+// processInChunks processes nChunks chunks of data concurrently.
+func processInChunks(data []byte, nChunks int) map[string]*measurement {
+	chunkSize := len(data) / nChunks
+	if chunkSize == 0 {
+		chunkSize = len(data)
+	}
+
+	chunks := stats.Chunks(data, chunkSize)
 
 	var wg sync.WaitGroup
 	wg.Add(len(chunks))
 
 	results := make([]map[string]*measurement, len(chunks))
-	start := 0
 	for i, chunk := range chunks {
 		go func(data []byte, i int) {
 			results[i] = processChunk(data)
 			wg.Done()
-		}(data[start:chunk], i)
-		start = chunk
+		}(chunk, i)
 	}
 	wg.Wait()
 
+	return mergeResults(results)
+}
+
+// mergeResults merges results of chunks. Sums of a single chunk can not
+// overflow int64 but merged sums may, so they are widened by stats.Merge.
+func mergeResults(results []map[string]*measurement) map[string]*measurement {
 	measurements := make(map[string]*measurement)
 	for _, r := range results {
-		for id, rm := range r {
-			m := measurements[id]
-			if m == nil {
-				measurements[id] = rm
-			} else {
-				m.min = min(m.min, rm.min)
-				m.max = max(m.max, rm.max)
-				m.sum += rm.sum
-				m.count += rm.count
-			}
-		}
+		stats.Merge(measurements, r)
 	}
 	return measurements
 }
 
 func processChunk(data []byte) map[string]*measurement {
-	// Use fixed size linear probe lookup table
+	// Use linear probe lookup table that grows when it is 3/4 full
 	const (
 		// use power of 2 for fast modulo calculation,
-		// should be larger than max number of keys which is 10_000
-		entriesSize = 1 << 14
+		// initial size fits max number of keys of the challenge which is 10_000
+		initialEntriesSize = 1 << 14
 
-		// use FNV-1a hash
+		// use FNV-1a hash of 8 byte words
 		fnv1aOffset64 = 14695981039346656037
 		fnv1aPrime64  = 1099511628211
 	)
 
 	type entry struct {
 		m     measurement
+		where *filter.Temperatures // selected temperatures if where is set
 		hash  uint64
 		vlen  int
 		value [128]byte // use power of 2 > 100 for alignment
+
+		// offset of the value in overflow if it is longer than 128 bytes
+		overflow int
 	}
-	entries := make([]entry, entriesSize)
+	entries := make([]entry, initialEntriesSize)
+	mask := uint64(initialEntriesSize - 1)
 	entriesCount := 0
+	var overflow []byte // values of long names
+	growAt := initialEntriesSize / 4 * 3
+	extended, histograms := fields.Extended(), *histogram
+
+	// emptyEntry returns the first empty entry for hash
+	emptyEntry := func(hash uint64) *entry {
+		i := hash & mask
+		for entries[i].vlen > 0 {
+			i = (i + 1) & mask
+		}
+		return &entries[i]
+	}
+
+	// grow doubles the table and returns the empty entry for hash,
+	// it moves entries so pointers to them become invalid
+	grow := func(hash uint64) *entry {
+		old := entries
+		entries = make([]entry, 2*len(old))
+		mask = uint64(len(entries) - 1)
+		growAt = len(entries) / 4 * 3
+
+		for i := range old {
+			if old[i].vlen > 0 {
+				*emptyEntry(old[i].hash) = old[i]
+			}
+		}
+		return emptyEntry(hash)
+	}
+
+	key := func(e *entry) []byte {
+		if e.vlen <= len(e.value) {
+			return e.value[:e.vlen]
+		}
+		return overflow[e.overflow : e.overflow+e.vlen]
+	}
 
 	// keep short and inlinable
-	getMeasurement := func(hash uint64, value []byte) *measurement {
-		i := hash & uint64(entriesSize-1)
+	getEntry := func(hash uint64, value []byte) *entry {
+		i := hash & mask
 		entry := &entries[i]
 
 		// bytes.Equal could be commented to speedup assuming no hash collisions
-		for entry.vlen > 0 && !(entry.hash == hash && bytes.Equal(entry.value[:entry.vlen], value)) {
-			i = (i + 1) & uint64(entriesSize-1)
+		for entry.vlen > 0 && !(entry.hash == hash && bytes.Equal(key(entry), value)) {
+			i = (i + 1) & mask
 			entry = &entries[i]
 		}
 
 		if entry.vlen == 0 {
+			if entriesCount == growAt {
+				entry = grow(hash)
+			}
 			entry.hash = hash
 			entry.vlen = copy(entry.value[:], value)
+			if entry.vlen < len(value) {
+				entry.vlen = len(value)
+				entry.overflow = len(overflow)
+				overflow = append(overflow, value...)
+			}
 			entriesCount++
 		}
-		return &entry.m
+		return entry
 	}
 
 	// assume valid input
 	for len(data) > 0 {
 
+		// scan and hash the name 8 bytes at a time,
+		// the last partial word is hashed the same way by the fallback loop
+		// so the hash does not depend on where the name is in data
 		idHash := uint64(fnv1aOffset64)
 		semiPos := 0
-		for i, b := range data {
-			if b == ';' {
-				semiPos = i
+		for {
+			if len(data)-semiPos < 8 {
+				var w uint64
+				for i, b := range data[semiPos:] {
+					if b == ';' {
+						semiPos += i
+						break
+					}
+					w |= uint64(b) << (8 * i)
+				}
+				idHash ^= w
+				idHash *= fnv1aPrime64
 				break
 			}
 
-			// calculate FNV-1a hash
-			idHash ^= uint64(b)
+			w := binary.LittleEndian.Uint64(data[semiPos:])
+			if found := hasByte(w, semicolons); found != 0 {
+				i := bits.TrailingZeros64(found) / 8
+				idHash ^= w & (1<<(8*i) - 1)
+				idHash *= fnv1aPrime64
+				semiPos += i
+				break
+			}
+			idHash ^= w
 			idHash *= fnv1aPrime64
+			semiPos += 8
 		}
+		// low bits select the entry so mix high bits into them
+		idHash ^= idHash >> 32
 
 		idData := data[:semiPos]
 
 		data = data[semiPos+1:]
 
 		var temp int64
-		// parseNumber
-		{
+		if len(data) >= 8 {
+			var n int
+			temp, n = parseNumberWord(binary.LittleEndian.Uint64(data))
+			data = data[n:]
+		} else {
+			// parseNumber near the end of data
 			negative := data[0] == '-'
 			if negative {
 				data = data[1:]
 			}
 
-			_ = data[3]
+			_ = data[2]
 			if data[1] == '.' {
-				// 1.2\n
+				// 1.2
 				temp = int64(data[0])*10 + int64(data[2]) - '0'*(10+1)
-				data = data[4:]
-				// 12.3\n
+				data = data[3:]
+				// 12.3
 			} else {
-				_ = data[4]
+				_ = data[3]
 				temp = int64(data[0])*100 + int64(data[1])*10 + int64(data[3]) - '0'*(100+10+1)
-				data = data[5:]
+				data = data[4:]
 			}
 
 			if negative {
@@ -214,70 +466,87 @@ func processChunk(data []byte) map[string]*measurement {
 			}
 		}
 
-		m := getMeasurement(idHash, idData)
-		if m.count == 0 {
-			m.min = temp
-			m.max = temp
-			m.sum = temp
-			m.count = 1
+		// skip \n or \r\n, the last record may have none
+		if len(data) > 0 && data[0] == '\r' {
+			data = data[1:]
+		}
+		if len(data) > 0 {
+			data = data[1:]
+		}
+
+		e := getEntry(idHash, idData)
+		if where != nil {
+			if e.where == nil {
+				e.where = where.Station(idData)
+			}
+			if !e.where.Contains(temp) {
+				continue
+			}
+		}
+
+		m := &e.m
+		if m.Count == 0 {
+			m.Min = temp
+			m.Max = temp
+			m.Sum = temp
+			m.Count = 1
+			if extended {
+				m.Extra = new(stats.Extra)
+				m.Extra.Add(temp)
+			}
+			if histograms {
+				m.Histogram = new(stats.Histogram)
+				m.Histogram.Add(temp)
+			}
 		} else {
-			m.min = min(m.min, temp)
-			m.max = max(m.max, temp)
-			m.sum += temp
-			m.count++
+			m.Min = min(m.Min, temp)
+			m.Max = max(m.Max, temp)
+			m.Sum += temp // can not overflow within a chunk, see mergeResults
+			m.Count++
+			if m.Extra != nil {
+				m.Extra.Add(temp)
+			}
+			if m.Histogram != nil {
+				m.Histogram.Add(temp)
+			}
 		}
 	}
 
 	result := make(map[string]*measurement, entriesCount)
 	for i := range entries {
 		entry := &entries[i]
-		if entry.m.count > 0 {
-			result[string(entry.value[:entry.vlen])] = &entry.m
+		if entry.m.Count > 0 {
+			result[string(key(entry))] = &entry.m
 		}
 	}
 	return result
 }
 
-func round(x float64) float64 {
-	return roundJava(x*10.0) / 10.0
-}
-
-// roundJava returns the closest integer to the argument, with ties
-// rounding to positive infinity, see java's Math.round
-func roundJava(x float64) float64 {
-	t := math.Trunc(x)
-	if x < 0.0 && t-x == 0.5 {
-		//return t
-	} else if math.Abs(x-t) >= 0.5 {
-		t += math.Copysign(1, x)
-	}
+const semicolons = 0x3B3B3B3B3B3B3B3B // ';' in every byte
 
-	if t == 0 { // check -0
-		return 0.0
-	}
-	return t
+// hasByte returns a word that has the high bit set in the byte where w
+// has the byte of pattern, e.g. semicolons, and possibly in the following
+// bytes. Use bits.TrailingZeros64 to find the first byte.
+func hasByte(w, pattern uint64) uint64 {
+	v := w ^ pattern
+	return (v - 0x0101010101010101) & ^v & 0x8080808080808080
 }
 
-// parseNumber reads decimal number that matches "^-?[0-9]{1,2}[.][0-9]" pattern,
-// e.g.: -12.3, -3.4, 5.6, 78.9 and return the value*10, i.e. -123, -34, 56, 789.
-func parseNumber(data []byte) int64 {
-	negative := data[0] == '-'
-	if negative {
-		data = data[1:]
-	}
-
-	var result int64
-	switch len(data) {
-	// 1.2
-	case 3:
-		result = int64(data[0])*10 + int64(data[2]) - '0'*(10+1)
-	// 12.3
-	case 4:
-		result = int64(data[0])*100 + int64(data[1])*10 + int64(data[3]) - '0'*(100+10+1)
-	}
-
-	if negative {
-		return -result
-	}
-	return result
+// parseNumberWord parses the temperature at the start of little-endian word w
+// which must match -?[0-9]{1,2}[.][0-9] pattern followed by any bytes.
+// It returns the temperature in tenths and the length of the number
+// which is the position of the new line after it.
+func parseNumberWord(w uint64) (temp int64, n int) {
+	// digits have 0x10 bit set and '.' has it unset,
+	// so the lowest unset bit of bytes 1-3 is the position of '.'
+	dotPos := bits.TrailingZeros64(^w & 0x10101000)
+
+	// -1 if the first byte is '-' which has 0x10 bit unset, 0 otherwise
+	sign := int64(^w<<59) >> 63
+	// clear the sign byte and align digits to bytes 2, 3 and 5
+	digits := ((w & ^uint64(sign&0xFF)) << (28 - dotPos)) & 0x0F000F0F00
+	// multiply digits by 100, 10 and 1 and sum them in bits 32-41
+	abs := int64((digits * 0x640a0001) >> 32 & 0x3FF)
+
+	return (abs ^ sign) - sign, dotPos/8 + 2
 }
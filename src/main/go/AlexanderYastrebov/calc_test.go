@@ -1,63 +1,31 @@
 package main
 
 import (
-	"fmt"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
-)
+	"testing/iotest"
 
-func TestRoundJava(t *testing.T) {
-	for _, tc := range []struct {
-		value    float64
-		expected string
-	}{
-		{value: -1.5, expected: "-1.0"},
-		{value: -1.0, expected: "-1.0"},
-		{value: -0.7, expected: "-1.0"},
-		{value: -0.5, expected: "0.0"},
-		{value: -0.3, expected: "0.0"},
-		{value: 0.0, expected: "0.0"},
-		{value: 0.3, expected: "0.0"},
-		{value: 0.5, expected: "1.0"},
-		{value: 0.7, expected: "1.0"},
-		{value: 1.0, expected: "1.0"},
-		{value: 1.5, expected: "2.0"},
-	} {
-		if rounded := roundJava(tc.value); fmt.Sprintf("%.1f", rounded) != tc.expected {
-			t.Errorf("Wrong rounding of %v, expected: %s, got: %.1f", tc.value, tc.expected, rounded)
-		}
-	}
-}
-
-func TestParseNumber(t *testing.T) {
-	for _, tc := range []struct {
-		value    string
-		expected string
-	}{
-		{value: "-99.9", expected: "-999"},
-		{value: "-12.3", expected: "-123"},
-		{value: "-1.5", expected: "-15"},
-		{value: "-1.0", expected: "-10"},
-		{value: "0.0", expected: "0"},
-		{value: "0.3", expected: "3"},
-		{value: "12.3", expected: "123"},
-		{value: "99.9", expected: "999"},
-	} {
-		if number := parseNumber([]byte(tc.value)); fmt.Sprintf("%d", number) != tc.expected {
-			t.Errorf("Wrong parsing of %v, expected: %s, got: %d", tc.value, tc.expected, number)
-		}
-	}
-}
-
-var parseNumberSink int64
-
-func BenchmarkParseNumber(b *testing.B) {
-	data1 := []byte("1.2")
-	data2 := []byte("-12.3")
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/filter"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/onebrctest"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
 
-	for i := 0; i < b.N; i++ {
-		parseNumberSink = parseNumber(data1) + parseNumber(data2)
-	}
+func TestSamples(t *testing.T) {
+	onebrctest.RunSamples(t, func(t *testing.T, path string) []byte {
+		return []byte(formatMeasurements(t, processFile(path)))
+	})
 }
 
 func BenchmarkProcess(b *testing.B) {
@@ -71,9 +39,9 @@ func BenchmarkProcess(b *testing.B) {
 	}
 
 	measurements := process(data)
-	rows := int64(0)
+	rows := uint64(0)
 	for _, m := range measurements {
-		rows += m.count
+		rows += m.Count
 	}
 
 	b.ReportAllocs()
@@ -84,3 +52,353 @@ func BenchmarkProcess(b *testing.B) {
 		process(data)
 	}
 }
+
+func BenchmarkProcess10K(b *testing.B) {
+	data, _ := uniqueKeys(10_000, 1_000_000)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		process(data)
+	}
+}
+
+// uniqueKeys returns records of n stations with names of up to 100 bytes
+// like CreateMeasurements3, each station has at least one record,
+// and their aggregates.
+func uniqueKeys(n, records int) ([]byte, map[string]*measurement) {
+	const letters = "abcdefghijklmnopqrstuvwxyz ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	rnd := rand.New(rand.NewSource(1))
+
+	names := make([]string, n)
+	for i := range names {
+		name := []byte(strconv.Itoa(i)) // digits make names unique
+		for l := 1 + rnd.Intn(stats.MaxNameLen); len(name) < l; {
+			name = append(name, letters[rnd.Intn(len(letters))])
+		}
+		names[i] = string(name)
+	}
+
+	var data []byte
+	aggregates := make(map[string]*measurement, n)
+	for i := 0; i < max(n, records); i++ {
+		name := names[i%n]
+		if i >= n {
+			name = names[rnd.Intn(n)]
+		}
+		temp := int64(rnd.Intn(stats.MaxTemperature-stats.MinTemperature+1) + stats.MinTemperature)
+
+		data = append(append(data, name...), ';')
+		data = append(stats.AppendTenths(data, temp), '\n')
+
+		if aggregates[name] == nil {
+			aggregates[name] = new(measurement)
+		}
+		aggregates[name].Add(temp)
+	}
+	return data, aggregates
+}
+
+func TestProcessUniqueKeys(t *testing.T) {
+	// each chunk has many more keys than the initial size of processChunk
+	// lookup table
+	data, expected := uniqueKeys(1_000_000, 1_000_000)
+	onebrctest.CompareAggregates(t, expected, processInChunks(data, 4))
+}
+
+func TestProcessReader(t *testing.T) {
+	samples, err := filepath.Glob("../../../test/resources/samples/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sample := range samples {
+		data, err := os.ReadFile(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := formatMeasurements(t, process(data))
+
+		// windows smaller than records are covered by TestProcessReaderRecordTooLong
+		for _, windowSize := range []int{128, 4096, len(data) + 1} {
+			if len(data)/windowSize > 100 {
+				continue // each window allocates processChunk lookup tables
+			}
+			for name, r := range map[string]io.Reader{
+				"reader":    bytes.NewReader(data),
+				"one byte":  iotest.OneByteReader(bytes.NewReader(data)),
+				"half read": iotest.HalfReader(bytes.NewReader(data)),
+			} {
+				measurements, err := processReader(r, windowSize)
+				if err != nil {
+					t.Fatalf("%s, %s, window %d: %v", sample, name, windowSize, err)
+				}
+				if got := formatMeasurements(t, measurements); got != expected {
+					t.Errorf("%s, %s, window %d: expected %s, got %s", sample, name, windowSize, expected, got)
+				}
+			}
+		}
+	}
+}
+
+func TestProcessReaderRecordTooLong(t *testing.T) {
+	_, err := processReader(strings.NewReader("Petropavlovsk-Kamchatsky;9.5\n"), 10)
+	if err != errRecordTooLong {
+		t.Errorf("Expected %v, got: %v", errRecordTooLong, err)
+	}
+}
+
+func TestCheckFileGzip(t *testing.T) {
+	fixture := onebrctest.NewFixture(t)
+	expected := formatMeasurements(t, fixture.Reference(t, newMeasurement, nil))
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	if _, err := gw.Write(fixture.Data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := fixture.Path + ".gz"
+	if err := os.WriteFile(path, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range []string{fixture.Path, path} {
+		if got := formatMeasurements(t, checkFile(filename)); got != expected {
+			t.Errorf("Wrong result of checkFile(%s), expected: %s, got: %s", filepath.Base(filename), expected, got)
+		}
+		if got := formatMeasurements(t, processFile(filename)); got != expected {
+			t.Errorf("Wrong result of processFile(%s), expected: %s, got: %s", filepath.Base(filename), expected, got)
+		}
+	}
+}
+
+func TestMergeResultsOverflow(t *testing.T) {
+	// per chunk results of a file with ~2^63/999 records per chunk
+	const count = math.MaxInt64 / 999
+	results := make([]map[string]*measurement, 4)
+	for i := range results {
+		chunk, err := processReader(strings.NewReader("a;99.9\nb;-99.9\nc;12.3\n"), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range chunk {
+			m.Sum *= count
+			m.Count *= count
+		}
+		results[i] = chunk
+	}
+
+	measurements := mergeResults(results)
+
+	if got := formatMeasurements(t, measurements); got != "{a=99.9/99.9/99.9, b=-99.9/-99.9/-99.9, c=12.3/12.3/12.3}\n" {
+		t.Errorf("Wrong merged result: %s", got)
+	}
+	for name, temp := range map[string]int64{"a": 999, "b": -999, "c": 123} {
+		m := measurements[name]
+		expected := big.NewInt(4 * count)
+		expected.Mul(expected, big.NewInt(temp))
+		if m.Count != 4*count || m.BigSum().Cmp(expected) != 0 {
+			t.Errorf("Wrong %s measurement, expected sum: %v, got: %+v", name, expected, m)
+		}
+	}
+}
+
+func TestProcessStats(t *testing.T) {
+	defer func(f stats.Fields) { fields = f }(fields)
+	var err error
+	fields, err = stats.ParseFields("min,mean,max,count,variance,stddev,p50,p90,p99")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := onebrctest.NewFixture(t)
+	expected := formatFields(t, fixture.Reference(t, fields.NewAggregate, nil))
+
+	for _, nChunks := range []int{1, 3, 8} {
+		if got := formatFields(t, processInChunks(fixture.Data, nChunks)); got != expected {
+			t.Errorf("Wrong result of %d chunks, expected: %s, got: %s", nChunks, expected, got)
+		}
+	}
+
+	measurements, err := processReader(bytes.NewReader(fixture.Data), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatFields(t, measurements); got != expected {
+		t.Errorf("Wrong result of processReader, expected: %s, got: %s", expected, got)
+	}
+}
+
+func TestProcessHistogram(t *testing.T) {
+	defer func(f stats.Fields, h bool) { fields, *histogram = f, h }(fields, *histogram)
+	var err error
+	fields, err = stats.ParseFields("min,median,mode,p99,max")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*histogram = true
+
+	fixture := onebrctest.NewFixture(t)
+	reference := fixture.Reference(t, newMeasurement, nil)
+	expected := formatFields(t, reference)
+
+	for _, nChunks := range []int{1, 3, 8} {
+		measurements := processInChunks(fixture.Data, nChunks)
+		if got := formatFields(t, measurements); got != expected {
+			t.Errorf("Wrong result of %d chunks, expected: %s, got: %s", nChunks, expected, got)
+		}
+		for name, m := range measurements {
+			if m.Histogram == nil || *m.Histogram != *reference[name].Histogram {
+				t.Errorf("Wrong histogram of %q with %d chunks", name, nChunks)
+			}
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "histogram.json")
+	if err := writeHistograms(filename, reference); err != nil {
+		t.Fatal(err)
+	}
+	var buckets map[string]map[string]uint64
+	if b, err := os.ReadFile(filename); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(b, &buckets); err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != len(reference) {
+		t.Errorf("Expected %d stations in JSON, got: %d", len(reference), len(buckets))
+	}
+}
+
+func TestProcessWhere(t *testing.T) {
+	defer func(w *filter.Filter) { where = w }(where)
+
+	fixture := onebrctest.NewFixture(t)
+	all := fixture.Reference(t, newMeasurement, nil)
+	rows := func(measurements map[string]*measurement) (n uint64) {
+		for _, m := range measurements {
+			n += m.Count
+		}
+		return
+	}
+
+	for _, expr := range []string{
+		`temp < 0`,
+		`station ~ "^[a-m]" and temp >= -10.5`,
+		`not station ~ "^[a-m]" or temp = 0`,
+		`station in ("nonexistent")`,
+	} {
+		var err error
+		where, err = filter.Parse(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reference := fixture.Reference(t, newMeasurement, where.Match)
+		if rows(reference) >= rows(all) {
+			t.Fatalf("%s: expected records to be filtered out", expr)
+		}
+		expected := formatMeasurements(t, reference)
+
+		for _, nChunks := range []int{1, 3, 8} {
+			if got := formatMeasurements(t, processInChunks(fixture.Data, nChunks)); got != expected {
+				t.Errorf("%s: wrong result of %d chunks, expected: %s, got: %s", expr, nChunks, expected, got)
+			}
+		}
+	}
+}
+
+func TestHasByte(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	word := make([]byte, 8)
+	for i := 0; i < 100_000; i++ {
+		for j := range word {
+			// mostly ';' and bytes that differ from it in one bit
+			switch r.Intn(4) {
+			case 0:
+				word[j] = ';'
+			case 1:
+				word[j] = ';' ^ 1<<r.Intn(8)
+			default:
+				word[j] = byte(r.Intn(256))
+			}
+		}
+
+		expected := bytes.IndexByte(word, ';')
+		got := -1
+		if found := hasByte(binary.LittleEndian.Uint64(word), semicolons); found != 0 {
+			got = bits.TrailingZeros64(found) / 8
+		}
+		if got != expected {
+			t.Fatalf("Wrong position of ';' in %q, expected: %d, got: %d", word, expected, got)
+		}
+	}
+}
+
+func TestParseNumberWord(t *testing.T) {
+	for temp := int64(stats.MinTemperature); temp <= stats.MaxTemperature; temp++ {
+		for _, next := range []string{"\n", "\r\n", "\nabcdefgh", "\n-99.9"} {
+			word := make([]byte, 8)
+			n := copy(word, stats.AppendTenths(nil, temp))
+			copy(word[n:], next)
+
+			got, gotN := parseNumberWord(binary.LittleEndian.Uint64(word))
+			if got != temp || gotN != n {
+				t.Fatalf("Wrong parsing of %q, expected: %d and length %d, got: %d and length %d", word, temp, n, got, gotN)
+			}
+		}
+	}
+}
+
+// TestProcessNamesNearEnd checks that names scanned by the fallback loop near
+// the end of data are hashed the same as the names scanned word at a time.
+func TestProcessNamesNearEnd(t *testing.T) {
+	var data []byte
+	for _, name := range []string{"a", "abcdefg", "abcdefgh", "abcdefghijklmno", "abcdefghijklmnop"} {
+		for _, temp := range []string{"1.0", "-1.0", "12.3", "-12.3"} {
+			data = append(data, name+";"+temp+"\n"...)
+		}
+	}
+	// every record is the last one with and without the new line
+	for end := len(data); end > 0; end-- {
+		if data[end-1] != '\n' {
+			continue
+		}
+		for _, chunk := range [][]byte{data[:end], data[:end-1]} {
+			onebrctest.CompareAggregates(t, onebrctest.Reference(t, data[:end]), process(bytes.Clone(chunk)))
+		}
+	}
+}
+
+func formatFields(t *testing.T, measurements map[string]*measurement) string {
+	t.Helper()
+
+	var out strings.Builder
+	if err := stats.FormatFields(&out, measurements, fields); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func formatMeasurements(t *testing.T, measurements map[string]*measurement) string {
+	t.Helper()
+
+	var out strings.Builder
+	if err := stats.Format(&out, measurements); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func FuzzProcess(f *testing.F) {
+	f.Add([]byte("seed data"), uint8(3))
+	f.Add([]byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b"), uint8(1))
+	f.Fuzz(func(t *testing.T, seed []byte, nChunks uint8) {
+		data := onebrctest.Measurements(seed)
+		onebrctest.CompareAggregates(t, onebrctest.Reference(t, data), processInChunks(data, int(nChunks)+1))
+	})
+}
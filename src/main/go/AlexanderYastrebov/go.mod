@@ -1,3 +1,7 @@
 module github.com/AlexanderYastrebov/1brc
 
 go 1.21.5
+
+require github.com/yusukemorita/1brc/src/main/go/onebrc v0.0.0
+
+replace github.com/yusukemorita/1brc/src/main/go/onebrc => ../onebrc
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+// state holds results of the previous run for the input that grows by
+// appending records, see processIncremental.
+type state struct {
+	// Size is the number of processed bytes, it always ends at a record boundary.
+	Size int64
+
+	// HeadSum and TailSum are checksums of the first and the last
+	// checksumSize processed bytes used to detect rewritten input.
+	HeadSum, TailSum uint32
+
+	// Extended and Histogram are set if measurements collect extra
+	// statistics and histograms.
+	Extended, Histogram bool
+
+	// Where is the filter expression, empty if all records are aggregated.
+	Where string
+
+	Measurements map[string]*measurement
+}
+
+const checksumSize = 64 * 1024
+
+func checksums(data []byte) (head, tail uint32) {
+	head = crc32.ChecksumIEEE(data[:min(len(data), checksumSize)])
+	tail = crc32.ChecksumIEEE(data[max(0, len(data)-checksumSize):])
+	return
+}
+
+// processIncremental processes only data appended since the run that saved
+// the state file and merges it with saved results.
+// It processes the whole data if there is no state file or if the input was
+// truncated or rewritten since. The last record without a new line is
+// aggregated but not saved, it is processed again by the next run.
+func processIncremental(data []byte, filename string) map[string]*measurement {
+	var offset int64
+	measurements := make(map[string]*measurement)
+
+	st, err := loadState(filename)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// first run
+	case err != nil:
+		log.Printf("Invalid state %s, processing whole file: %v", filename, err)
+	case st.Size > int64(len(data)):
+		log.Printf("File is truncated since the previous run, processing whole file")
+	case !checksumsMatch(data[:st.Size], st):
+		log.Printf("File is rewritten since the previous run, processing whole file")
+	case st.Extended != fields.Extended() || st.Histogram != *histogram:
+		log.Printf("Statistics changed since the previous run, processing whole file")
+	case st.Where != where.String():
+		log.Printf("Filter changed since the previous run, processing whole file")
+	default:
+		offset = st.Size
+		measurements = st.Measurements
+	}
+
+	end := int64(bytes.LastIndexByte(data, '\n') + 1)
+	if end > offset {
+		stats.Merge(measurements, process(data[offset:end]))
+	} else {
+		end = offset
+	}
+
+	st = &state{Size: end, Extended: fields.Extended(), Histogram: *histogram, Where: where.String(), Measurements: measurements}
+	st.HeadSum, st.TailSum = checksums(data[:end])
+
+	if err := saveState(filename, st); err != nil {
+		log.Fatalf("Save state: %v", err)
+	}
+
+	// the last record may have no new line, it is merged after the state
+	// is saved so the next run reads it again in case it is incomplete
+	if int64(len(data)) > end {
+		stats.Merge(measurements, process(data[end:]))
+	}
+	return measurements
+}
+
+func checksumsMatch(data []byte, st *state) bool {
+	head, tail := checksums(data)
+	return head == st.HeadSum && tail == st.TailSum
+}
+
+func loadState(filename string) (*state, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st := &state{}
+	if err := gob.NewDecoder(f).Decode(st); err != nil {
+		return nil, err
+	}
+	if st.Size < 0 {
+		return nil, errors.New("negative size")
+	}
+	if st.Measurements == nil { // gob does not encode empty maps
+		st.Measurements = make(map[string]*measurement)
+	}
+	return st, nil
+}
+
+// saveState replaces the state file atomically so that interrupted run
+// does not leave it partially written.
+func saveState(filename string, st *state) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(st); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
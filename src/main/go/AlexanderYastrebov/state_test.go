@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/filter"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func TestProcessIncremental(t *testing.T) {
+	data, err := os.ReadFile("../../../test/resources/samples/measurements-10000-unique-keys.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := formatMeasurements(t, process(data))
+
+	half := bytes.IndexByte(data[len(data)/2:], '\n') + len(data)/2 + 1
+	rewritten := bytes.Clone(data)
+	rewritten[0] = 'X' // changes first station name
+
+	for _, tc := range []struct {
+		name     string
+		previous []byte
+		data     []byte
+	}{
+		{name: "first run", data: data},
+		{name: "appended", previous: data[:half], data: data},
+		{name: "appended to record without new line", previous: data[:half-1], data: data},
+		{name: "no final new line", data: data[:len(data)-1]},
+		{name: "appended without final new line", previous: data[:half], data: data[:len(data)-1]},
+		{name: "unchanged without final new line", previous: data[:len(data)-1], data: data[:len(data)-1]},
+		{name: "unchanged", previous: data, data: data},
+		{name: "truncated", previous: append(bytes.Clone(data), "Extra;1.0\n"...), data: data},
+		{name: "rewritten", previous: data, data: rewritten},
+		{name: "empty", previous: data, data: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "state")
+			if tc.previous != nil {
+				processIncremental(tc.previous, filename)
+			}
+
+			want := expected
+			if !bytes.Equal(tc.data, data) {
+				want = formatMeasurements(t, process(tc.data))
+			}
+
+			if got := formatMeasurements(t, processIncremental(tc.data, filename)); got != want {
+				t.Errorf("Expected %s, got %s", want, got)
+			}
+
+			// next run without new data loads the same results from the state
+			if got := formatMeasurements(t, processIncremental(tc.data, filename)); got != want {
+				t.Errorf("Expected %s after reload, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestProcessIncrementalStatsChanged(t *testing.T) {
+	defer func(f stats.Fields) { fields = f }(fields)
+
+	data := []byte("a;1.0\na;2.0\nb;-3.0\n")
+	filename := filepath.Join(t.TempDir(), "state")
+	processIncremental(data, filename)
+
+	var err error
+	fields, err = stats.ParseFields("min,max,stddev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// extra statistics are not saved, so the whole file must be processed
+	expected := "{a=1.0/2.0/0.5, b=-3.0/-3.0/0.0}\n"
+	for i := 0; i < 2; i++ {
+		if got := formatFields(t, processIncremental(data, filename)); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+	}
+}
+
+func TestProcessIncrementalWhereChanged(t *testing.T) {
+	defer func(w *filter.Filter) { where = w }(where)
+
+	data := []byte("a;1.0\na;2.0\nb;-3.0\n")
+	filename := filepath.Join(t.TempDir(), "state")
+	processIncremental(data, filename)
+
+	var err error
+	where, err = filter.Parse("temp > 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "{a=1.0/1.5/2.0}\n"
+	for i := 0; i < 2; i++ {
+		if got := formatMeasurements(t, processIncremental(data, filename)); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+	}
+}
@@ -1,24 +1,26 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
 	"runtime"
-	"runtime/pprof"
-	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
-	"unsafe"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/filter"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/groupby"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/output"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/profile"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
 )
 
-// go run main.go [measurements_file]
+// go run main.go [flags] [measurements_file]
 // tune env vars for performance
 //
 // Environment variables:
@@ -26,16 +28,30 @@ import (
 //   			          to runtime.NumCPU()
 // - PARSE_CHUNK_SIZE_MB: size of each chunk to parse. if unset, defaults to
 //                        defaultParseChunkSize
-// - PROFILE:             if "true", enables profiling
-
-var (
-	// others: "heap", "threadcreate", "block", "mutex"
-	profileTypes = []string{"goroutine", "allocs"}
-)
+// - PROFILE:             if "true", writes cpu, memory, block and mutex profiles
+//                        to profiles/<unix time>/ unless set by flags
+//
+// Profiling flags: -cpuprofile, -memprofile, -blockprofile, -mutexprofile, -trace
+//
+// Output flags: -output-format=canonical|json|json-object|ndjson|csv|tsv|parquet
+// and -output=<file> to write results to a file instead of stdout
+//
+// Validation flags, both are much slower as they do not assume valid input:
+// - -validate:     report each malformed record and fail if there is any
+// - -skip-invalid: skip and count malformed records
+//
+// -where='station ~ "^San" and temp < 0' aggregates only matching records, see
+// package filter for the expression syntax
+//
+// -group-by=prefix:N|regex:EXPR|csv:FILE[:COLUMN] aggregates by keys derived
+// from station names, e.g. by country from a CSV file of stations
+//
+// -stats=min,mean,max,count,variance,stddev,mode,median,p99 selects statistics
+// to print, all but min, mean, max and count are slower as they need extra
+// statistics or histograms
 
 const (
 	defaultMeasurementsPath = "measurements.txt"
-	maxNameLen              = 100
 	maxNameNum              = 10000
 
 	// tuned for a 2023 Macbook M2 Pro
@@ -43,28 +59,23 @@ const (
 	mb                      = 1024 * 1024 // bytes
 )
 
-type Stats struct {
-	Min, Max, Sum float64
-	Count         int
-}
-
-// rounding floats to 1 decimal place with 0.05 rounding up to 0.1
-func round(x float64) float64 {
-	return math.Floor((x+0.05)*10) / 10
-}
+// Stats holds min, max and sum of temperatures in tenths of a degree, so that
+// they are accumulated exactly unlike float64 values.
+type Stats = stats.Aggregate
 
-// parseFloatFast is a high performance float parser using the assumption that
-// the byte slice will always have a single decimal digit.
-func parseFloatFast(bs []byte) float64 {
+// parseTenthsFast is a high performance parser using the assumption that the
+// byte slice will always have a single decimal digit. It returns the value in
+// tenths of a degree, e.g. 12.3 is 123.
+func parseTenthsFast(bs []byte) int64 {
 	var intStartIdx int // is negative?
 	if bs[0] == '-' {
 		intStartIdx = 1
 	}
 
-	v := float64(bs[len(bs)-1]-'0') / 10 // single decimal digit
-	place := 1.0
+	v := int64(bs[len(bs)-1] - '0') // single decimal digit
+	place := int64(10)
 	for i := len(bs) - 3; i >= intStartIdx; i-- { // integer part
-		v += float64(bs[i]-'0') * place
+		v += int64(bs[i]-'0') * place
 		place *= 10
 	}
 
@@ -76,21 +87,35 @@ func parseFloatFast(bs []byte) float64 {
 
 // size is the intended number of bytes to parse. buffer should be longer than size
 // because we need to continue reading until the end of the line in order to
-// properly segment the entire file and not miss any data.
-func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
+// properly segment the entire file and not miss any data. stats also collect
+// extra statistics and histograms if fields need them, see stats.Fields.
+// Only records selected by where are parsed into stats unless it is nil.
+func parseAt(f *os.File, buf []byte, offset int64, size int, fields stats.Fields, where *filter.Filter) map[string]*Stats {
 	stats := make(map[string]*Stats, maxNameNum)
+	var wheres map[string]*filter.Temperatures // selected temperatures of each station
+	if where != nil {
+		wheres = make(map[string]*filter.Temperatures, maxNameNum)
+	}
+
+	// a line that starts exactly at offset belongs to this chunk, so also read
+	// the preceding byte to tell whether it ends the previous line
+	isFirstChunk := offset == 0
+	if !isFirstChunk {
+		offset--
+		size++
+	}
 	n, err := f.ReadAt(buf, offset) // load the buffer
 	if err != nil && err != io.EOF {
 		log.Fatal(err)
 	}
 
-	lastName := make([]byte, maxNameLen) // last name parsed
-	var lastNameLen int
+	var lastName []byte    // last name parsed, references buf so it is not limited in length
 	isScanningName := true // currently scanning name or value?
 
-	// if offset is non-zero, skip to the first new line
+	// if offset is non-zero, skip past the first new line, which may be the
+	// preceding byte
 	var idx, start int
-	if offset != 0 {
+	if !isFirstChunk {
 		for idx < n {
 			if buf[idx] == '\n' {
 				idx++
@@ -99,14 +124,19 @@ func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 			}
 			idx++
 		}
+		// the first line starts in one of the next chunks, or there is no
+		// new line, i.e. the chunk is within the last record of the file
+		// that starts in one of the previous chunks
+		if idx >= size || start == 0 {
+			return stats
+		}
 	}
 	// tick tock between parsing names and values while accummulating stats
 	for {
 		if isScanningName {
 			for idx < n {
 				if buf[idx] == ';' {
-					nameBs := buf[start:idx]
-					lastNameLen = copy(lastName, nameBs)
+					lastName = buf[start:idx]
 
 					idx++
 					start = idx
@@ -119,12 +149,18 @@ func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 			for idx < n {
 				if buf[idx] == '\n' {
 					valueBs := buf[start:idx]
-					value := parseFloatFast(valueBs)
-
-					nameUnsafe := unsafe.String(&lastName[0], lastNameLen)
-					if s, ok := stats[nameUnsafe]; !ok {
-						name := string(lastName[:lastNameLen]) // actually allocate string
-						stats[name] = &Stats{Min: value, Max: value, Sum: value, Count: 1}
+					if valueBs[len(valueBs)-1] == '\r' {
+						valueBs = valueBs[:len(valueBs)-1]
+					}
+					value := parseTenthsFast(valueBs)
+
+					if where != nil && !selected(where, wheres, lastName, value) {
+						// filtered out
+					} else if s, ok := stats[string(lastName)]; !ok { // does not allocate
+						name := string(lastName) // actually allocate string
+						s = fields.NewAggregate()
+						s.Add(value)
+						stats[name] = s
 					} else {
 						if value < s.Min {
 							s.Min = value
@@ -132,8 +168,14 @@ func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 						if value > s.Max {
 							s.Max = value
 						}
-						s.Sum += value
+						s.Sum += value // can not overflow within a chunk
 						s.Count++
+						if s.Extra != nil {
+							s.Extra.Add(value)
+						}
+						if s.Histogram != nil {
+							s.Histogram.Add(value)
+						}
 					}
 
 					idx++
@@ -151,36 +193,101 @@ func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 		}
 	}
 
+	// the last record starts within the chunk but has no new line, i.e. it is
+	// the last one of the file, or does not fit into the buffer, e.g. it has
+	// a long name, so read the rest of it
+	if idx >= n && (start < n || !isScanningName) {
+		recordStart := start
+		if !isScanningName {
+			recordStart -= len(lastName) + 1
+		}
+		record := buf[recordStart:n]
+		if n == len(buf) {
+			record, err = readRecord(f, record, offset+int64(n))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		name, valueBs, _ := bytes.Cut(bytes.TrimSuffix(record, []byte{'\r'}), []byte{';'})
+		value := parseTenthsFast(valueBs)
+		if where == nil || selected(where, wheres, name, value) {
+			s, ok := stats[string(name)]
+			if !ok {
+				s = fields.NewAggregate()
+				stats[string(name)] = s
+			}
+			s.Add(value)
+		}
+	}
+
 	return stats
 }
 
-func printResults(stats map[string]*Stats) { // doesn't help
-	// sorted alphabetically for output
-	names := make([]string, 0, len(stats))
-	for name := range stats {
-		names = append(names, name)
-	}
-	sort.Strings(names)
-
-	var builder strings.Builder
-	for i, name := range names {
-		s := stats[name]
-		// gotcha: first round the sum to to remove float precision errors!
-		avg := round(round(s.Sum) / float64(s.Count))
-		builder.WriteString(fmt.Sprintf("%s=%.1f/%.1f/%.1f", name, s.Min, avg, s.Max))
-		if i < len(names)-1 {
-			builder.WriteString(", ")
+// readRecord returns the record that starts with head followed by the bytes
+// of f from offset up to the next new line or the end of the file.
+func readRecord(f *os.File, head []byte, offset int64) ([]byte, error) {
+	record := bytes.Clone(head)
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return append(record, buf[:i]...), nil
+		}
+		record = append(record, buf[:n]...)
+		offset += int64(n)
+		if err == io.EOF {
+			return record, nil
 		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// selected reports whether where selects the record, wheres caches the
+// temperatures selected for each station.
+func selected(where *filter.Filter, wheres map[string]*filter.Temperatures, name []byte, value int64) bool {
+	temperatures, ok := wheres[string(name)]
+	if !ok {
+		temperatures = where.Station(name)
+		wheres[string(name)] = temperatures
+	}
+	return temperatures.Contains(value)
+}
+
+func printResults(results map[string]*Stats, outputs output.Config, fields stats.Fields) {
+	if err := outputs.Write(results, fields); err != nil {
+		log.Fatal(fmt.Errorf("failed to write results: %w", err))
+	}
+}
+
+// checkFile parses the file without assuming valid input, see stats.Check.
+// If strict, it fails on malformed records after reporting all of them.
+func checkFile(f *os.File, path string, strict bool, fields stats.Fields, where *filter.Filter) map[string]*Stats {
+	var keep func(name []byte, temp int64) bool
+	if where != nil {
+		keep = where.Match
 	}
 
-	writer := bufio.NewWriter(os.Stdout)
-	fmt.Fprintf(writer, "{%s}\n", builder.String())
-	writer.Flush()
+	invalid := 0
+	aggregates, err := stats.CheckWhere(f, fields.NewAggregate, keep, func(record *stats.InvalidRecord) {
+		invalid++
+		if strict {
+			log.Printf("%s: %v", path, record)
+		}
+	})
+	if err != nil {
+		log.Fatal(fmt.Errorf("failed to read %s file: %w", path, err))
+	}
+	if invalid > 0 {
+		if strict {
+			log.Fatalf("found %d invalid records", invalid)
+		}
+		log.Printf("skipped %d invalid records", invalid)
+	}
+	return aggregates
 }
 
-// Read file in chunks and parse concurrently. N parsers work off of a chunk
-// offset chan and send results on an output chan. The results are merged into a
-// single map of stats and printed.
 func main() {
 	// parse env vars and inputs
 	shouldProfile := os.Getenv("PROFILE") == "true"
@@ -209,28 +316,55 @@ func main() {
 		}
 	}
 
+	var profiles profile.Config
+	profiles.RegisterFlags(flag.CommandLine)
+	validate := flag.Bool("validate", false, "report each malformed record and fail if there is any")
+	skipInvalid := flag.Bool("skip-invalid", false, "skip and count malformed records instead of assuming valid input")
+	fields := stats.DefaultFields
+	flag.Var(&fields, "stats", stats.FieldsUsage)
+	var outputs output.Config
+	outputs.RegisterFlags(flag.CommandLine)
+	var groups groupby.Config
+	groups.RegisterFlags(flag.CommandLine)
+	whereExpr := flag.String("where", "", "aggregate only records matching `expression`, e.g. 'station ~ \"^San\" and temp < 0'")
+	flag.Parse()
+	if err := outputs.Check(); err != nil {
+		log.Fatal(err)
+	}
+	if err := groups.Load(); err != nil {
+		log.Fatal(fmt.Errorf("failed to load group-by keys: %w", err))
+	}
+	var where *filter.Filter
+	if *whereExpr != "" {
+		where, err = filter.Parse(*whereExpr)
+		if err != nil {
+			log.Fatal(fmt.Errorf("failed to parse where expression: %w", err))
+		}
+	}
 	measurementsPath := defaultMeasurementsPath
-	if len(os.Args) > 1 {
-		measurementsPath = os.Args[1]
+	if flag.NArg() > 0 {
+		measurementsPath = flag.Arg(0)
 	}
 
 	// profile code
 	if shouldProfile {
-		nowUnix := time.Now().Unix()
-		os.MkdirAll(fmt.Sprintf("profiles/%d", nowUnix), 0755)
-		for _, profileType := range profileTypes {
-			file, _ := os.Create(fmt.Sprintf("profiles/%d/%s.%s.pprof",
-				nowUnix, filepath.Base(measurementsPath), profileType))
-			defer file.Close()
-			defer pprof.Lookup(profileType).WriteTo(file, 0)
+		dir := fmt.Sprintf("profiles/%d", time.Now().Unix())
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(fmt.Errorf("failed to create %s directory: %w", dir, err))
+		}
+		base := filepath.Join(dir, filepath.Base(measurementsPath))
+		for p, filename := range map[*string]string{
+			&profiles.CPU:    base + ".cpu.pprof",
+			&profiles.Memory: base + ".heap.pprof",
+			&profiles.Block:  base + ".block.pprof",
+			&profiles.Mutex:  base + ".mutex.pprof",
+		} {
+			if *p == "" {
+				*p = filename
+			}
 		}
-
-		file, _ := os.Create(fmt.Sprintf("profiles/%d/%s.cpu.pprof",
-			nowUnix, filepath.Base(measurementsPath)))
-		defer file.Close()
-		pprof.StartCPUProfile(file)
-		defer pprof.StopCPUProfile()
 	}
+	defer profiles.Start()()
 
 	// read file
 	f, err := os.Open(measurementsPath)
@@ -239,11 +373,23 @@ func main() {
 	}
 	defer f.Close()
 
+	if *validate || *skipInvalid {
+		printResults(groups.Apply(checkFile(f, measurementsPath, *validate, fields, where)), outputs, fields)
+		return
+	}
+
 	info, err := f.Stat()
 	if err != nil {
 		log.Fatal(fmt.Errorf("failed to read %s file: %w", measurementsPath, err))
 	}
 
+	printResults(groups.Apply(processFile(f, info.Size(), numParsers, parseChunkSize, fields, where)), outputs, fields)
+}
+
+// processFile reads file in chunks and parses them concurrently. N parsers work
+// off of a chunk offset chan and send results on an output chan. The results
+// are merged into a single map of stats.
+func processFile(f *os.File, size int64, numParsers, parseChunkSize int, fields stats.Fields, where *filter.Filter) map[string]*Stats {
 	// kick off "parser" workers
 	wg := sync.WaitGroup{}
 	wg.Add(numParsers)
@@ -254,7 +400,7 @@ func main() {
 
 	go func() {
 		i := 0
-		for i < int(info.Size()) {
+		for i < int(size) {
 			chunkOffsetCh <- int64(i)
 			i += parseChunkSize
 		}
@@ -263,12 +409,13 @@ func main() {
 
 	for i := 0; i < numParsers; i++ {
 		// WARN: w/ extra padding for line overflow. Each chunk should be read past
-		// the intended size to the next new line. 128 bytes should be enough for
-		// a max 100 byte name + the float value.
+		// the intended size to the next new line. 128 bytes are enough for
+		// a max 100 byte name + the float value, the rest of longer records is
+		// read by parseAt.
 		buf := make([]byte, parseChunkSize+128)
 		go func() {
 			for chunkOffset := range chunkOffsetCh {
-				chunkStatsCh <- parseAt(f, buf, chunkOffset, parseChunkSize)
+				chunkStatsCh <- parseAt(f, buf, chunkOffset, parseChunkSize, fields, where)
 			}
 			wg.Done()
 		}()
@@ -281,21 +428,9 @@ func main() {
 
 	mergedStats := make(map[string]*Stats, maxNameNum)
 	for chunkStats := range chunkStatsCh {
-		for name, s := range chunkStats {
-			if ms, ok := mergedStats[name]; !ok {
-				mergedStats[name] = s
-			} else {
-				if s.Min < ms.Min {
-					ms.Min = s.Min
-				}
-				if s.Max > ms.Max {
-					ms.Max = s.Max
-				}
-				ms.Sum += s.Sum
-				ms.Count += s.Count
-			}
-		}
+		// sums of a chunk fit into int64, merged sums are widened on overflow
+		stats.Merge(mergedStats, chunkStats)
 	}
 
-	printResults(mergedStats)
+	return mergedStats
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/filter"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/onebrctest"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func TestSamples(t *testing.T) {
+	for _, parseChunkSize := range []int{defaultParseChunkSizeMB * mb, 1024} {
+		onebrctest.RunSamples(t, func(t *testing.T, path string) []byte {
+			f := openFile(t, path)
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			stats.FormatFields(&out, processFile(f, info.Size(), 4, parseChunkSize, stats.DefaultFields, nil), stats.DefaultFields)
+			return out.Bytes()
+		})
+	}
+}
+
+func TestProcessFileStats(t *testing.T) {
+	fields, err := stats.ParseFields("min,mean,max,count,variance,stddev,mode,median,p90,p99")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := onebrctest.NewFixture(t)
+	f := openFile(t, fixture.Path)
+
+	var expected bytes.Buffer
+	stats.FormatFields(&expected, fixture.Reference(t, fields.NewAggregate, nil), fields)
+
+	for _, parseChunkSize := range []int{100, 4096, defaultParseChunkSizeMB * mb} {
+		var out bytes.Buffer
+		stats.FormatFields(&out, processFile(f, int64(len(fixture.Data)), 4, parseChunkSize, fields, nil), fields)
+		if out.String() != expected.String() {
+			t.Errorf("Wrong result with chunk size %d, expected: %s, got: %s", parseChunkSize, expected.String(), out.String())
+		}
+	}
+}
+
+func TestProcessFileWhere(t *testing.T) {
+	fixture := onebrctest.NewFixture(t)
+	f := openFile(t, fixture.Path)
+
+	for _, expr := range []string{`temp < 0`, `station ~ "^[a-m]" and temp >= -10.5`, `not station ~ "^[a-m]" or temp = 0`} {
+		where, err := filter.Parse(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expected bytes.Buffer
+		stats.Format(&expected, fixture.Reference(t, stats.DefaultFields.NewAggregate, where.Match))
+
+		var checked bytes.Buffer
+		stats.Format(&checked, checkFile(openFile(t, fixture.Path), fixture.Path, true, stats.DefaultFields, where))
+		if checked.String() != expected.String() {
+			t.Errorf("%s: wrong result of checkFile, expected: %s, got: %s", expr, expected.String(), checked.String())
+		}
+
+		for _, parseChunkSize := range []int{100, 4096, defaultParseChunkSizeMB * mb} {
+			var out bytes.Buffer
+			stats.Format(&out, processFile(f, int64(len(fixture.Data)), 4, parseChunkSize, stats.DefaultFields, where))
+			if out.String() != expected.String() {
+				t.Errorf("%s: wrong result with chunk size %d, expected: %s, got: %s", expr, parseChunkSize, expected.String(), out.String())
+			}
+		}
+	}
+}
+
+func TestProcessFileLongRecords(t *testing.T) {
+	// all records are longer than the 128 bytes parseAt reads past the chunk,
+	// so each chunk boundary falls inside a record that does not fit
+	var data []byte
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("%d %s", i%13, strings.Repeat("Sé", []int{100, 300, 1000, 2048}[i%4]))
+		data = append(append(data, name...), ';')
+		data = append(stats.AppendTenths(data, int64(i*37%1999-999)), '\n')
+	}
+	chunkSizes := []int{64, 128, 129, 300, 1000, 4096, len(data)}
+
+	for _, tc := range []struct {
+		name       string
+		data       []byte
+		chunkSizes []int
+	}{
+		{"final new line", data, chunkSizes},
+		{"no final new line", data[:len(data)-1], chunkSizes},
+		// chunks after the first one have no new line
+		{"long last record without new line", []byte("a;1.0\n" + strings.Repeat("b", 300) + ";2.0"), []int{10, 64, 77, 100, 154, 200, 306}},
+	} {
+		expected, err := stats.CheckLimits(bytes.NewReader(tc.data), stats.DefaultFields.NewAggregate, nil, stats.Limits{}, func(r *stats.InvalidRecord) {
+			t.Fatalf("Invalid record: %v", r)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f := openFile(t, onebrctest.TempFile(t, tc.data))
+		for _, parseChunkSize := range tc.chunkSizes {
+			t.Logf("%s, chunk size %d", tc.name, parseChunkSize)
+			onebrctest.CompareAggregates(t, expected, processFile(f, int64(len(tc.data)), 4, parseChunkSize, stats.DefaultFields, nil))
+		}
+	}
+}
+
+// openFile opens the file at path until the end of the test.
+func openFile(t testing.TB, path string) *os.File {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// TestProcessFileChunkBoundaries is a regression test of records that start
+// exactly at a chunk offset, which were skipped because parseAt skipped to
+// the first new line after the offset, and of chunks without a new line,
+// which parsed the record that starts in the next chunk again.
+func TestProcessFileChunkBoundaries(t *testing.T) {
+	data := []byte("ab;1.0\ncd;-2.5\nab;3.0\nefgh;10.0\nab;-0.1\n")
+	f := openFile(t, onebrctest.TempFile(t, data))
+
+	for parseChunkSize := 1; parseChunkSize <= len(data); parseChunkSize++ {
+		for _, numParsers := range []int{1, 4} {
+			onebrctest.CompareAggregates(t, onebrctest.Reference(t, data), processFile(f, int64(len(data)), numParsers, parseChunkSize, stats.DefaultFields, nil))
+		}
+	}
+}
+
+func FuzzParseTenthsFast(f *testing.F) {
+	for _, s := range []string{"-99.9", "-12.3", "-1.5", "0.0", "-0.0", "12.3", "99.9"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if !stats.ValidTemperature([]byte(s)) {
+			t.Skip() // parseTenthsFast assumes valid input
+		}
+
+		expected := stats.ParseTemperature([]byte(s))
+		if got := parseTenthsFast([]byte(s)); got != expected {
+			t.Errorf("Wrong parsing of %q, expected: %d, got: %d", s, expected, got)
+		}
+	})
+}
+
+func FuzzProcessFile(f *testing.F) {
+	f.Add([]byte("seed data"), uint8(3), uint16(10))
+	f.Add([]byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b"), uint8(1), uint16(6))
+	f.Fuzz(func(t *testing.T, seed []byte, numParsers uint8, parseChunkSize uint16) {
+		data := onebrctest.Measurements(seed)
+
+		f := openFile(t, onebrctest.TempFile(t, data))
+		actual := processFile(f, int64(len(data)), int(numParsers%8)+1, int(parseChunkSize)+1, stats.DefaultFields, nil)
+
+		onebrctest.CompareAggregates(t, onebrctest.Reference(t, data), actual)
+	})
+}
+
+// TestRounding checks means that were off by 0.1 with float64 sums, e.g.
+// -99.65 printed as -99.7, and that the result does not depend on the order
+// of summation, i.e. on the chunk size.
+func TestRounding(t *testing.T) {
+	sample, err := os.ReadFile(filepath.Join(onebrctest.SamplesDir, "measurements-rounding.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile(filepath.Join(onebrctest.SamplesDir, "measurements-rounding.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		data, expected string
+	}{
+		{"measurements-rounding", string(sample), string(expected)},
+		{"negative ties", "a;-99.6\na;-99.7\nb;-0.1\nb;-0.2\nc;-45.2\nc;-45.3\n", "{a=-99.7/-99.6/-99.6, b=-0.2/-0.1/-0.1, c=-45.3/-45.2/-45.2}\n"},
+		{"positive ties", "a;99.6\na;99.7\nb;0.1\nb;0.2\nc;45.2\nc;45.3\n", "{a=99.6/99.7/99.7, b=0.1/0.2/0.2, c=45.2/45.3/45.3}\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := openFile(t, onebrctest.TempFile(t, []byte(tc.data)))
+			for _, parseChunkSize := range []int{16, 100, 1000, 4096, defaultParseChunkSizeMB * mb} {
+				var out bytes.Buffer
+				stats.FormatFields(&out, processFile(f, int64(len(tc.data)), 4, parseChunkSize, stats.DefaultFields, nil), stats.DefaultFields)
+				if out.String() != tc.expected {
+					t.Errorf("Wrong result with chunk size %d, expected: %s, got: %s", parseChunkSize, tc.expected, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// Command compare runs several solvers on the same measurements file and
+// reports per station discrepancies with a reference computed from the input
+// by stats.CheckLimits without the challenge limits on the number of stations
+// and the name length, e.g. from the repository root:
+//
+//	go -C src/main/go/onebrc run ./cmd/compare ../../../../measurements.txt
+//	go -C src/main/go/onebrc run ./cmd/compare -solver "elh-docker=$PWD/target/elh/1brc-go" ../../../../measurements.txt
+//
+// Solvers are commands that get absolute path of the measurements file as
+// the last argument and print results in the challenge format. By default
+// the Go solvers of this repository are run with go run.
+//
+// For each differing mean it lists rounding rules that explain the
+// solver's value, e.g. elh's floor(x+0.05) in float64 or ceil.
+// It exits with status 1 if there are discrepancies.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+type solver struct {
+	name    string
+	command []string
+}
+
+type solvers []solver
+
+func (s *solvers) String() string {
+	names := make([]string, len(*s))
+	for i, sv := range *s {
+		names[i] = sv.name
+	}
+	return strings.Join(names, ",")
+}
+
+func (s *solvers) Set(value string) error {
+	name, command, found := strings.Cut(value, "=")
+	if !found || name == "" || len(strings.Fields(command)) == 0 {
+		return fmt.Errorf("expected name=command, got: %q", value)
+	}
+	*s = append(*s, solver{name: name, command: strings.Fields(command)})
+	return nil
+}
+
+// defaultSolvers are run from src/main/go/onebrc directory.
+var defaultSolvers = solvers{
+	{name: "AlexanderYastrebov", command: []string{"go", "-C", "../AlexanderYastrebov", "run", "."}},
+	{name: "elh", command: []string{"go", "-C", "../elh", "run", "."}},
+	{name: "yusukemorita", command: []string{"go", "-C", "../yusukemorita", "run", "."}},
+}
+
+func main() {
+	var ss solvers
+	flag.Var(&ss, "solver", "solver `name=command` to run, may be repeated, defaults to Go solvers of this repository")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <measurements file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ss) == 0 {
+		ss = defaultSolvers
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reference, err := checkReference(f, func(r *stats.InvalidRecord) {
+		log.Printf("Skipped invalid record, %v", r)
+	})
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var discrepancies []discrepancy
+	for _, s := range ss {
+		results, err := run(s, path)
+		if err != nil {
+			log.Fatalf("%s: %v", s.name, err)
+		}
+		d := compare(s.name, reference, results)
+		log.Printf("%s: %d stations, %d discrepancies", s.name, len(results), len(d))
+		discrepancies = append(discrepancies, d...)
+	}
+
+	if len(discrepancies) > 0 {
+		printDiscrepancies(os.Stdout, discrepancies)
+		os.Exit(1)
+	}
+}
+
+func run(s solver, path string) ([]stats.Result, error) {
+	cmd := exec.Command(s.command[0], append(s.command[1:], path)...)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return stats.ParseResults(out)
+}
+
+type discrepancy struct {
+	solver, station, field string
+	expected, actual       string
+	explanation            string
+}
+
+// compare returns discrepancies of solver results with reference aggregates.
+func compare(solver string, reference map[string]*stats.Aggregate, results []stats.Result) []discrepancy {
+	var ds []discrepancy
+
+	seen := make(map[string]bool, len(results))
+	for _, r := range results {
+		seen[r.Name] = true
+
+		a := reference[r.Name]
+		if a == nil {
+			ds = append(ds, discrepancy{solver: solver, station: r.Name, field: "station", expected: "-", actual: r.String(), explanation: "unexpected station"})
+			continue
+		}
+
+		for _, field := range []struct {
+			name             string
+			expected, actual float64
+		}{
+			{"min", float64(a.Min) / 10, r.Min},
+			{"mean", a.Mean(), r.Mean},
+			{"max", float64(a.Max) / 10, r.Max},
+		} {
+			expected, actual := format(field.expected), format(field.actual)
+			if expected == actual {
+				continue
+			}
+
+			explanation := "parsing or accumulation error"
+			if field.name == "mean" {
+				explanation = explain(a, actual)
+			}
+			ds = append(ds, discrepancy{solver: solver, station: r.Name, field: field.name, expected: expected, actual: actual, explanation: explanation})
+		}
+	}
+
+	for _, name := range stats.SortedNames(reference) {
+		if !seen[name] {
+			ds = append(ds, discrepancy{solver: solver, station: name, field: "station", expected: name + "=" + reference[name].String(), actual: "-", explanation: "missing station"})
+		}
+	}
+	return ds
+}
+
+func format(x float64) string {
+	return fmt.Sprintf("%.1f", x)
+}
+
+// rule rounds mean of sum/count tenths of a degree to one decimal place.
+type rule struct {
+	name  string
+	round func(sum, count int64) float64
+}
+
+// rules are rounding rules used by known solvers, means are computed exactly
+// unless the rule says otherwise.
+var rules = []rule{
+	{"java Math.round, ties toward positive infinity", func(sum, count int64) float64 {
+		return float64(stats.FloorDiv(2*sum+count, 2*count)) / 10
+	}},
+	{"elh floor(x+0.05) in float64", func(sum, count int64) float64 {
+		round := func(x float64) float64 { return math.Floor((x+0.05)*10) / 10 }
+		return round(round(float64(sum)/10) / float64(count))
+	}},
+	{"ceil", func(sum, count int64) float64 {
+		return float64(-stats.FloorDiv(-sum, count)) / 10
+	}},
+	{"floor", func(sum, count int64) float64 {
+		return float64(stats.FloorDiv(sum, count)) / 10
+	}},
+	{"truncate", func(sum, count int64) float64 {
+		return float64(sum/count) / 10
+	}},
+	{"ties away from zero", func(sum, count int64) float64 {
+		if sum < 0 {
+			return -float64(stats.FloorDiv(-2*sum+count, 2*count)) / 10
+		}
+		return float64(stats.FloorDiv(2*sum+count, 2*count)) / 10
+	}},
+	{"ties to even", func(sum, count int64) float64 {
+		q := stats.FloorDiv(sum, count)
+		r := sum - q*count // 0 <= r < count
+		if 2*r > count || 2*r == count && q%2 != 0 {
+			q++
+		}
+		return float64(q) / 10
+	}},
+	{"float64 mean with java Math.round", func(sum, count int64) float64 {
+		return stats.Round(float64(sum) / 10 / float64(count))
+	}},
+}
+
+// explain returns comma separated names of rules that round mean of a to actual.
+func explain(a *stats.Aggregate, actual string) string {
+	if a.SumHigh != 0 || a.Sum < -1<<61 || a.Sum > 1<<61 || a.Count > 1<<61 {
+		return "sum is too large to evaluate rounding rules"
+	}
+
+	var names []string
+	for _, r := range rules {
+		if format(r.round(a.Sum, int64(a.Count))) == actual {
+			names = append(names, r.name)
+		}
+	}
+	if len(names) == 0 {
+		return "no known rounding rule, accumulation error"
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkReference aggregates records of r checked by stats.CheckLimits
+// without the challenge limits on the number of stations and the name
+// length, which the solvers do not enforce either.
+func checkReference(r io.Reader, onInvalid func(*stats.InvalidRecord)) (map[string]*stats.Aggregate, error) {
+	return stats.CheckLimits(r, stats.DefaultFields.NewAggregate, nil, stats.Limits{}, onInvalid)
+}
+
+func printDiscrepancies(w io.Writer, ds []discrepancy) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOLVER\tSTATION\tFIELD\tEXPECTED\tACTUAL\tEXPLAINED BY")
+	for _, d := range ds {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.solver, d.station, d.field, d.expected, d.actual, d.explanation)
+	}
+	tw.Flush()
+	w.Write(buf.Bytes())
+}
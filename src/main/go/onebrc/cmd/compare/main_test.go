@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func TestRules(t *testing.T) {
+	for _, tc := range []struct {
+		sum, count int64
+		expected   map[string]string
+	}{
+		{5, 2, map[string]string{ // 0.25
+			"java Math.round, ties toward positive infinity": "0.3",
+			"ceil":                "0.3",
+			"floor":               "0.2",
+			"truncate":            "0.2",
+			"ties away from zero": "0.3",
+			"ties to even":        "0.2",
+		}},
+		{-3, 2, map[string]string{ // -0.15
+			"java Math.round, ties toward positive infinity": "-0.1",
+			"ceil":                "-0.1",
+			"floor":               "-0.2",
+			"truncate":            "-0.1",
+			"ties away from zero": "-0.2",
+			"ties to even":        "-0.2",
+		}},
+		{-7, 3, map[string]string{ // -0.2333
+			"java Math.round, ties toward positive infinity": "-0.2",
+			"ceil":                "-0.2",
+			"floor":               "-0.3",
+			"truncate":            "-0.2",
+			"ties away from zero": "-0.2",
+			"ties to even":        "-0.2",
+		}},
+	} {
+		for _, r := range rules {
+			expected, ok := tc.expected[r.name]
+			if !ok {
+				continue
+			}
+			if actual := format(r.round(tc.sum, tc.count)); actual != expected {
+				t.Errorf("Wrong %s of %d/%d, expected: %s, got: %s", r.name, tc.sum, tc.count, expected, actual)
+			}
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	reference, err := stats.Check(strings.NewReader("a;0.2\na;0.3\nb;-1.0\nc;5.0\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := stats.ParseResults([]byte("{a=0.2/0.2/0.3, b=-1.0/-1.0/-0.9, d=1.0/1.0/1.0}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := compare("test", reference, results)
+
+	var buf bytes.Buffer
+	printDiscrepancies(&buf, ds)
+	expected := `SOLVER  STATION  FIELD    EXPECTED       ACTUAL         EXPLAINED BY
+test    a        mean     0.3            0.2            floor, truncate, ties to even
+test    b        max      -1.0           -0.9           parsing or accumulation error
+test    d        station  -              d=1.0/1.0/1.0  unexpected station
+test    c        station  c=5.0/5.0/5.0  -              missing station
+`
+	if actual := buf.String(); actual != expected {
+		t.Errorf("Wrong discrepancies, expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestCheckReference(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < stats.MaxStations; i++ {
+		fmt.Fprintf(&input, "s%d;1.0\n", i)
+	}
+	long := strings.Repeat("ж", stats.MaxNameLen)
+	input.WriteString(long + ";2.0\n")
+
+	reference, err := checkReference(strings.NewReader(input.String()), func(r *stats.InvalidRecord) {
+		t.Errorf("Unexpected invalid record: %v", r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reference) != stats.MaxStations+1 || reference[long] == nil {
+		t.Errorf("Expected %d stations including the long name, got: %d", stats.MaxStations+1, len(reference))
+	}
+}
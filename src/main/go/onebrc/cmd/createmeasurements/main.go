@@ -0,0 +1,309 @@
+// Command createmeasurements is a Go port of CreateMeasurements that does
+// not need a JVM. It reads station names and latitudes from
+// data/weather_stations.csv and writes records with temperatures drawn from
+// a normal distribution around each station's mean temperature, which is
+// estimated from the latitude, e.g.:
+//
+//	go run ./cmd/createmeasurements -o measurements.txt 1000000000
+//
+// The file lists about 41K distinct names, so by default it picks a random
+// sample of 10,000 of them, the maximum number of stations allowed by the
+// challenge rules. Use -all-stations to generate records of all of them.
+//
+// With -keys it instead generates the given number of synthetic station
+// names of up to 100 bytes like CreateMeasurements3, e.g. for the 10K
+// unique keys variant:
+//
+//	go run ./cmd/createmeasurements -keys 10000 -stddev 7 -o measurements3.txt 1000000000
+//
+// Records are generated in parallel in blocks, each with its own random
+// source derived from -seed, so the output only depends on the flags and
+// not on the number of workers.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+const blockSize = 1 << 20 // records
+
+type station struct {
+	name     string
+	meanTemp float64
+}
+
+func main() {
+	var (
+		stationsFile = flag.String("stations", "data/weather_stations.csv", "weather stations `file` with name;latitude rows")
+		output       = flag.String("o", "measurements.txt", "output `file`")
+		seed         = flag.Int64("seed", 1, "random seed")
+		stddev       = flag.Float64("stddev", 10, "standard deviation of temperatures, CreateMeasurements3 uses 7")
+		keys         = flag.Int("keys", 0, "generate this number of synthetic station names like CreateMeasurements3, 0 uses names from the stations file")
+		allStations  = flag.Bool("all-stations", false, "use all stations of the stations file instead of a sample of 10,000, i.e. beyond the challenge rules")
+		workers      = flag.Int("workers", runtime.NumCPU(), "number of goroutines generating records")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <number of records to create>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	n, err := strconv.ParseInt(flag.Arg(0), 10, 64)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid value for <number of records to create>: %s", flag.Arg(0))
+	}
+	if *workers < 1 || *keys < 0 || *stddev < 0 {
+		log.Fatalf("Invalid flags: workers=%d keys=%d stddev=%v", *workers, *keys, *stddev)
+	}
+
+	f, err := os.Open(*stationsFile)
+	if err != nil {
+		log.Fatalf("Open: %v", err)
+	}
+	stations, err := readStations(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Read %s: %v", *stationsFile, err)
+	}
+
+	if *keys > 0 {
+		stations, err = syntheticStations(stations, *keys, rand.New(rand.NewSource(*seed)))
+		if err != nil {
+			log.Fatalf("Generate station names: %v", err)
+		}
+	} else if !*allStations {
+		stations = sampleStations(stations, stats.MaxStations, rand.New(rand.NewSource(*seed)))
+	}
+
+	out, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Create: %v", err)
+	}
+
+	start := time.Now()
+	if err := generate(out, stations, n, *seed, *stddev, *workers); err != nil {
+		log.Fatalf("Write: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Close: %v", err)
+	}
+	log.Printf("Created file with %d measurements of %d stations in %v", n, len(stations), time.Since(start))
+}
+
+// readStations reads name;latitude rows skipping # comments and estimates
+// mean temperature from the latitude like CreateMeasurements3.
+func readStations(r io.Reader) ([]station, error) {
+	var stations []station
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		row := scanner.Text()
+		if row == "" || strings.HasPrefix(row, "#") {
+			continue
+		}
+
+		name, latitude, found := strings.Cut(row, ";")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid row: %q", row)
+		}
+		lat, err := strconv.ParseFloat(latitude, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latitude: %q", row)
+		}
+
+		stations = append(stations, station{name: name, meanTemp: 30*math.Cos(lat*math.Pi/180) - 10})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stations) == 0 {
+		return nil, errors.New("no stations")
+	}
+	return stations, nil
+}
+
+// sampleStations returns a random sample of at most n stations with distinct
+// names, the first station of the same name is used.
+func sampleStations(stations []station, n int, rnd *rand.Rand) []station {
+	seen := make(map[string]bool, len(stations))
+	distinct := make([]station, 0, len(stations))
+	for _, s := range stations {
+		if !seen[s.name] {
+			seen[s.name] = true
+			distinct = append(distinct, s)
+		}
+	}
+
+	rnd.Shuffle(len(distinct), func(i, j int) {
+		distinct[i], distinct[j] = distinct[j], distinct[i]
+	})
+	return distinct[:min(n, len(distinct))]
+}
+
+// syntheticStations returns n stations with unique names of up to
+// stats.MaxNameLen bytes cut out of concatenated names of source stations,
+// mean temperatures are taken from source stations in order.
+func syntheticStations(source []station, n int, rnd *rand.Rand) ([]station, error) {
+	if n > len(source) {
+		return nil, fmt.Errorf("not enough source stations: %d < %d", len(source), n)
+	}
+
+	var all strings.Builder
+	for _, s := range source {
+		all.WriteString(s.name)
+	}
+	names := []rune(all.String())
+	errExhausted := errors.New("name source exhausted")
+
+	readNonSpace := func() (rune, error) {
+		for len(names) > 0 {
+			r := names[0]
+			names = names[1:]
+			if !unicode.IsSpace(r) {
+				return r, nil
+			}
+		}
+		return 0, errExhausted
+	}
+
+	stations := make([]station, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		// Use a 7th-order curve to simulate the name length distribution.
+		// It gives us mostly short names, but with large outliers.
+		nameLen := max(1, int(4+2500*math.Pow(rnd.Float64()-0.372, 7)))
+		if nameLen > len(names) {
+			return nil, errExhausted
+		}
+		name := append([]rune(nil), names[:nameLen]...)
+		names = names[nameLen:]
+
+		var err error
+		fixSpaces := func() {
+			if unicode.IsSpace(name[0]) {
+				name[0], err = readNonSpace()
+			}
+			if err == nil && unicode.IsSpace(name[len(name)-1]) {
+				name[len(name)-1], err = readNonSpace()
+			}
+		}
+
+		fixSpaces()
+		for err == nil && len(string(name)) > stats.MaxNameLen {
+			name = name[:len(name)-1]
+			fixSpaces()
+		}
+		for err == nil && seen[string(name)] {
+			name[rnd.Intn(len(name))], err = readNonSpace()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		seen[string(name)] = true
+		stations = append(stations, station{name: string(name), meanTemp: source[i].meanTemp})
+	}
+	return stations, nil
+}
+
+// generate writes n records to w, blocks of records are generated by workers
+// in parallel and written in order.
+func generate(w io.Writer, stations []station, n, seed int64, stddev float64, workers int) error {
+	nBlocks := (n + blockSize - 1) / blockSize
+
+	// limit number of generated blocks waiting to be written
+	tokens := make(chan struct{}, 2*workers)
+	blockCh := make(chan int64)
+	results := make([]chan []byte, nBlocks)
+	for i := range results {
+		results[i] = make(chan []byte, 1)
+	}
+
+	go func() {
+		for i := int64(0); i < nBlocks; i++ {
+			tokens <- struct{}{}
+			blockCh <- i
+		}
+		close(blockCh)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for block := range blockCh {
+				records := min(blockSize, n-block*blockSize)
+				results[block] <- generateBlock(stations, records, blockSeed(seed, block), stddev)
+			}
+		}()
+	}
+
+	var err error
+	for _, result := range results {
+		data := <-result
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		<-tokens
+	}
+	wg.Wait()
+	return err
+}
+
+// blockSeed derives independent seed for each block using SplitMix64 mixer.
+func blockSeed(seed, block int64) int64 {
+	z := uint64(seed) + uint64(block+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+func generateBlock(stations []station, records int64, seed int64, stddev float64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+
+	data := make([]byte, 0, records*16)
+	for i := int64(0); i < records; i++ {
+		s := &stations[rnd.Intn(len(stations))]
+		temp := s.meanTemp + rnd.NormFloat64()*stddev
+
+		data = append(data, s.name...)
+		data = append(data, ';')
+		data = appendTemperature(data, temp)
+		data = append(data, '\n')
+	}
+	return data
+}
+
+// appendTemperature appends temp rounded like java's Math.round to one
+// decimal place and clamped to the valid range of -99.9..99.9.
+func appendTemperature(data []byte, temp float64) []byte {
+	t := int64(math.Floor(temp*10 + 0.5))
+	t = max(stats.MinTemperature, min(stats.MaxTemperature, t))
+	if t < 0 {
+		data = append(data, '-')
+		t = -t
+	}
+	data = strconv.AppendInt(data, t/10, 10)
+	return append(data, '.', byte('0'+t%10))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func readTestStations(t *testing.T) []station {
+	t.Helper()
+
+	f, err := os.Open("../../../../../../data/weather_stations.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stations, err := readStations(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stations
+}
+
+func TestReadStations(t *testing.T) {
+	stations, err := readStations(strings.NewReader("# comment\n# another comment\nTokyo;35.6897\nQuito;-0.2200\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 2 || stations[0].name != "Tokyo" || stations[1].name != "Quito" {
+		t.Fatalf("Unexpected stations: %v", stations)
+	}
+	if stations[1].meanTemp < 19.9 || stations[1].meanTemp > 20 {
+		t.Errorf("Expected mean temperature close to 20 on the equator, got: %v", stations[1].meanTemp)
+	}
+
+	if _, err := readStations(strings.NewReader("Tokyo,35.6897\n")); err == nil {
+		t.Error("Expected error for invalid row")
+	}
+}
+
+func TestAppendTemperature(t *testing.T) {
+	for _, tc := range []struct {
+		value    float64
+		expected string
+	}{
+		{value: 0, expected: "0.0"},
+		{value: -0.04, expected: "0.0"},
+		{value: -0.05, expected: "0.0"},
+		{value: -0.06, expected: "-0.1"},
+		{value: 0.05, expected: "0.1"},
+		{value: 12.34, expected: "12.3"},
+		{value: -12.35, expected: "-12.3"},
+		{value: 99.96, expected: "99.9"},
+		{value: -123.4, expected: "-99.9"},
+	} {
+		if got := string(appendTemperature(nil, tc.value)); got != tc.expected {
+			t.Errorf("Wrong temperature for %v, expected: %s, got: %s", tc.value, tc.expected, got)
+		}
+	}
+}
+
+func TestSyntheticStations(t *testing.T) {
+	stations, err := syntheticStations(readTestStations(t), 10_000, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+	for _, s := range stations {
+		if len(s.name) == 0 || len(s.name) > stats.MaxNameLen || strings.ContainsAny(s.name, ";\n") {
+			t.Errorf("Invalid name: %q", s.name)
+		}
+		if strings.TrimSpace(s.name) != s.name {
+			t.Errorf("Name has leading or trailing space: %q", s.name)
+		}
+		names[s.name] = true
+	}
+	if len(names) != 10_000 {
+		t.Errorf("Expected 10000 unique names, got: %d", len(names))
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	stations := readTestStations(t)[:100]
+	const n = blockSize + 1000
+
+	var sequential, parallel bytes.Buffer
+	if err := generate(&sequential, stations, n, 42, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(&parallel, stations, n, 42, 10, 4); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sequential.Bytes(), parallel.Bytes()) {
+		t.Error("Output depends on the number of workers")
+	}
+
+	aggregates, err := stats.Check(&sequential, func(r *stats.InvalidRecord) {
+		t.Errorf("Invalid record: %v", r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := uint64(0)
+	for _, a := range aggregates {
+		count += a.Count
+	}
+	if count != n {
+		t.Errorf("Expected %d records, got: %d", n, count)
+	}
+	if len(aggregates) != len(stations) {
+		t.Errorf("Expected %d stations, got: %d", len(stations), len(aggregates))
+	}
+}
+
+// TestGenerateDefaultStations checks that records of the default sample of
+// stations are valid by the challenge rules.
+func TestGenerateDefaultStations(t *testing.T) {
+	all := readTestStations(t)
+	stations := sampleStations(all, stats.MaxStations, rand.New(rand.NewSource(1)))
+	if len(stations) != stats.MaxStations {
+		t.Fatalf("Expected %d stations out of %d, got: %d", stats.MaxStations, len(all), len(stations))
+	}
+
+	var out bytes.Buffer
+	if err := generate(&out, stations, 200_000, 1, 10, 4); err != nil {
+		t.Fatal(err)
+	}
+	aggregates, err := stats.Check(&out, func(r *stats.InvalidRecord) {
+		t.Fatalf("Invalid record: %v", r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggregates) < stats.MaxStations*9/10 {
+		t.Errorf("Expected most of %d stations, got: %d", stats.MaxStations, len(aggregates))
+	}
+}
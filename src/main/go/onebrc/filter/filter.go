@@ -0,0 +1,518 @@
+// Package filter selects records by -where expressions on station names and
+// temperatures, e.g.
+//
+//	station ~ "^San" and temp < 0
+//	station in ("Hamburg", "Berlin") or not (temp >= -10.0 and temp <= 10.0)
+//	station glob "St. *" and temp != 0
+//
+// Station conditions are ~ and !~ for regular expressions, = and != for
+// names, glob with * and ? wildcards and [...] classes, and in (...) or
+// not in (...) for lists of names. Temperature conditions compare temp
+// (or temperature) with a number with at most one fractional digit using
+// <, <=, >, >=, = and !=. Conditions are combined with and, or, not and
+// parentheses, && || and ! are accepted too.
+//
+// Station conditions do not depend on the temperature, so solvers evaluate
+// them once per station via Filter.Station which returns the set of
+// temperatures the station's records must have.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+const numTemperatures = stats.MaxTemperature - stats.MinTemperature + 1
+
+// Temperatures is a set of valid temperatures in tenths of a degree.
+type Temperatures struct {
+	bits [(numTemperatures + 63) / 64]uint64
+}
+
+// Contains reports whether temp is in the set, temperatures out of the
+// valid range are not.
+func (t *Temperatures) Contains(temp int64) bool {
+	i := uint64(temp - stats.MinTemperature)
+	return i < numTemperatures && t.bits[i/64]&(1<<(i%64)) != 0
+}
+
+func (t *Temperatures) add(temp int64) {
+	i := uint64(temp - stats.MinTemperature)
+	t.bits[i/64] |= 1 << (i % 64)
+}
+
+var (
+	all  = allTemperatures()
+	none Temperatures
+)
+
+func allTemperatures() (t Temperatures) {
+	for temp := int64(stats.MinTemperature); temp <= stats.MaxTemperature; temp++ {
+		t.add(temp)
+	}
+	return
+}
+
+// Filter is a parsed -where expression, it is safe for concurrent use.
+type Filter struct {
+	expr string
+	root node
+
+	// temperatures of every station if the expression has no station conditions
+	static *Temperatures
+}
+
+// Parse parses the expression, see the package documentation.
+func Parse(expr string) (*Filter, error) {
+	p := &parser{input: expr}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+
+	f := &Filter{expr: expr, root: root}
+	if !root.hasStation() {
+		t := root.temperatures("")
+		f.static = &t
+	}
+	return f, nil
+}
+
+// String returns the expression, empty for nil filter.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// Station returns the temperatures of the station's records that match the
+// filter, the returned set may be shared and must not be modified.
+// It does not retain name.
+func (f *Filter) Station(name []byte) *Temperatures {
+	if f.static != nil {
+		return f.static
+	}
+	t := f.root.temperatures(string(name))
+	switch t {
+	case all:
+		return &all
+	case none:
+		return &none
+	}
+	return &t
+}
+
+// Match reports whether the record matches the filter. It evaluates
+// station conditions for each record, solvers should cache Station instead.
+func (f *Filter) Match(name []byte, temp int64) bool {
+	return f.root.match(string(name), temp)
+}
+
+type node interface {
+	match(station string, temp int64) bool
+	// temperatures returns the set of temperatures that match for the station
+	temperatures(station string) Temperatures
+	hasStation() bool
+}
+
+type and [2]node
+
+func (n and) match(s string, t int64) bool { return n[0].match(s, t) && n[1].match(s, t) }
+func (n and) hasStation() bool             { return n[0].hasStation() || n[1].hasStation() }
+func (n and) temperatures(s string) (t Temperatures) {
+	a, b := n[0].temperatures(s), n[1].temperatures(s)
+	for i := range t.bits {
+		t.bits[i] = a.bits[i] & b.bits[i]
+	}
+	return
+}
+
+type or [2]node
+
+func (n or) match(s string, t int64) bool { return n[0].match(s, t) || n[1].match(s, t) }
+func (n or) hasStation() bool             { return n[0].hasStation() || n[1].hasStation() }
+func (n or) temperatures(s string) (t Temperatures) {
+	a, b := n[0].temperatures(s), n[1].temperatures(s)
+	for i := range t.bits {
+		t.bits[i] = a.bits[i] | b.bits[i]
+	}
+	return
+}
+
+type not struct{ n node }
+
+func (n not) match(s string, t int64) bool { return !n.n.match(s, t) }
+func (n not) hasStation() bool             { return n.n.hasStation() }
+func (n not) temperatures(s string) (t Temperatures) {
+	a := n.n.temperatures(s)
+	for i := range t.bits {
+		t.bits[i] = all.bits[i] &^ a.bits[i]
+	}
+	return
+}
+
+// station is a condition on the station name only.
+type station func(name string) bool
+
+func (n station) match(s string, _ int64) bool { return n(s) }
+func (n station) hasStation() bool             { return true }
+func (n station) temperatures(s string) Temperatures {
+	if n(s) {
+		return all
+	}
+	return none
+}
+
+// temperature compares temperature with a value in tenths.
+type temperature struct {
+	op    string
+	value int64
+}
+
+func (n temperature) match(_ string, t int64) bool {
+	switch n.op {
+	case "<":
+		return t < n.value
+	case "<=":
+		return t <= n.value
+	case ">":
+		return t > n.value
+	case ">=":
+		return t >= n.value
+	case "=", "==":
+		return t == n.value
+	}
+	return t != n.value
+}
+
+func (n temperature) hasStation() bool { return false }
+
+func (n temperature) temperatures(string) (t Temperatures) {
+	for temp := int64(stats.MinTemperature); temp <= stats.MaxTemperature; temp++ {
+		if n.match("", temp) {
+			t.add(temp)
+		}
+	}
+	return
+}
+
+// globRegexp converts glob pattern to an anchored regular expression.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString(`\A(?:`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			sb.WriteString(`(?s:.*)`)
+		case '?':
+			sb.WriteString(`(?s:.)`)
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in glob %q", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += 1 + end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString(`)\z`)
+	return regexp.Compile(sb.String())
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // unquoted for strings
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type parser struct {
+	input string
+	pos   int
+	tok   token
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next reads the next token into p.tok.
+func (p *parser) next() error {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+	start := p.pos
+	p.tok = token{pos: start}
+	if p.pos == len(p.input) {
+		p.tok.kind = tokEOF
+		return nil
+	}
+
+	rest := p.input[p.pos:]
+	switch c := rest[0]; {
+	case c == '"' || c == '`':
+		prefix, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return p.errorf("invalid string: %v", err)
+		}
+		p.tok.kind = tokString
+		p.tok.text, _ = strconv.Unquote(prefix)
+		p.pos += len(prefix)
+	case c == '-' || c >= '0' && c <= '9':
+		end := 1
+		for end < len(rest) && (rest[end] == '.' || rest[end] >= '0' && rest[end] <= '9') {
+			end++
+		}
+		p.tok.kind = tokNumber
+		p.tok.text = rest[:end]
+		p.pos += end
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		end := 1
+		for end < len(rest) && (rest[end] == '_' || rest[end] >= 'a' && rest[end] <= 'z' || rest[end] >= 'A' && rest[end] <= 'Z') {
+			end++
+		}
+		p.tok.kind = tokWord
+		p.tok.text = strings.ToLower(rest[:end])
+		p.pos += end
+	default:
+		for _, op := range []string{"!~", "!=", "<=", ">=", "==", "&&", "||", "~", "=", "<", ">", "!", "(", ")", ","} {
+			if strings.HasPrefix(rest, op) {
+				p.tok.kind = tokOp
+				p.tok.text = op
+				p.pos += len(op)
+				return nil
+			}
+		}
+		return p.errorf("unexpected %q", c)
+	}
+	return nil
+}
+
+func (p *parser) is(texts ...string) bool {
+	return (p.tok.kind == tokWord || p.tok.kind == tokOp) && slices.Contains(texts, p.tok.text)
+}
+
+// or parses: and { ("or" | "||") and }
+func (p *parser) or() (node, error) {
+	n, err := p.and()
+	for err == nil && p.is("or", "||") {
+		var right node
+		if err = p.next(); err == nil {
+			right, err = p.and()
+			n = or{n, right}
+		}
+	}
+	return n, err
+}
+
+// and parses: unary { ("and" | "&&") unary }
+func (p *parser) and() (node, error) {
+	n, err := p.unary()
+	for err == nil && p.is("and", "&&") {
+		var right node
+		if err = p.next(); err == nil {
+			right, err = p.unary()
+			n = and{n, right}
+		}
+	}
+	return n, err
+}
+
+// unary parses: ("not" | "!") unary | "(" or ")" | condition
+func (p *parser) unary() (node, error) {
+	switch {
+	case p.is("not", "!"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.unary()
+		return not{n}, err
+	case p.is("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.errorf("expected ), got %s", p.tok)
+		}
+		return n, p.next()
+	case p.is("station"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return p.station()
+	case p.is("temp", "temperature"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return p.temperature()
+	}
+	return nil, p.errorf("expected condition, got %s", p.tok)
+}
+
+// station parses the condition after "station".
+func (p *parser) station() (node, error) {
+	op := p.tok
+	if p.is("not") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if !p.is("in") {
+			return nil, p.errorf("expected in, got %s", p.tok)
+		}
+		n, err := p.station()
+		return not{n}, err
+	}
+	if !p.is("~", "!~", "=", "==", "!=", "glob", "in") {
+		return nil, p.errorf("expected station operator, got %s", p.tok)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if op.text == "in" {
+		names, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		return station(func(name string) bool { _, ok := names[name]; return ok }), nil
+	}
+
+	value, err := p.string()
+	if err != nil {
+		return nil, err
+	}
+	switch op.text {
+	case "=", "==":
+		return station(func(name string) bool { return name == value }), nil
+	case "!=":
+		return station(func(name string) bool { return name != value }), nil
+	}
+
+	var re *regexp.Regexp
+	if op.text == "glob" {
+		re, err = globRegexp(value)
+	} else {
+		re, err = regexp.Compile(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if op.text == "!~" {
+		return station(func(name string) bool { return !re.MatchString(name) }), nil
+	}
+	return station(re.MatchString), nil
+}
+
+// list parses: "(" string { "," string } ")"
+func (p *parser) list() (map[string]struct{}, error) {
+	if !p.is("(") {
+		return nil, p.errorf("expected (, got %s", p.tok)
+	}
+	names := make(map[string]struct{})
+	for {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		names[name] = struct{}{}
+		if p.is(")") {
+			return names, p.next()
+		}
+		if !p.is(",") {
+			return nil, p.errorf("expected , or ), got %s", p.tok)
+		}
+	}
+}
+
+func (p *parser) string() (string, error) {
+	if p.tok.kind != tokString {
+		return "", p.errorf("expected string, got %s", p.tok)
+	}
+	s := p.tok.text
+	return s, p.next()
+}
+
+var errInvalidNumber = errors.New("expected number with at most one fractional digit")
+
+// temperature parses the comparison after "temp".
+func (p *parser) temperature() (node, error) {
+	if !p.is("<", "<=", ">", ">=", "=", "==", "!=") {
+		return nil, p.errorf("expected comparison, got %s", p.tok)
+	}
+	op := p.tok.text
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokNumber {
+		return nil, p.errorf("expected number, got %s", p.tok)
+	}
+	value, err := parseTenths(p.tok.text)
+	if err != nil {
+		return nil, p.errorf("%v: %s", err, p.tok)
+	}
+	return temperature{op, value}, p.next()
+}
+
+// parseTenths parses -?[0-9]+([.][0-9])? in tenths.
+func parseTenths(s string) (int64, error) {
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if hasFrac && len(frac) != 1 || strings.HasPrefix(whole, "+") {
+		return 0, errInvalidNumber
+	}
+	v, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || whole == "" || whole == "-" {
+		return 0, errInvalidNumber
+	}
+	if !hasFrac {
+		if v > 1<<59 || v < -1<<59 {
+			return 0, errInvalidNumber
+		}
+		v *= 10
+	}
+	return v, nil
+}
@@ -0,0 +1,111 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func TestFilter(t *testing.T) {
+	for _, tc := range []struct {
+		expr    string
+		station string
+		temp    int64
+		match   bool
+	}{
+		{`station ~ "^San" and temp < 0`, "San Juan", -1, true},
+		{`station ~ "^San" and temp < 0`, "San Juan", 0, false},
+		{`station ~ "^San" and temp < 0`, "Hamburg", -1, false},
+		{`station !~ "^San"`, "Hamburg", 0, true},
+		{`station = "Hamburg"`, "Hamburg", 0, true},
+		{`station == "Hamburg"`, "Hamburger", 0, false},
+		{`station != "Hamburg"`, "Hamburg", 0, false},
+		{`station in ("Hamburg", "Berlin")`, "Berlin", 0, true},
+		{`station in ("Hamburg")`, "Berlin", 0, false},
+		{`station not in ("Hamburg", "Berlin")`, "Berlin", 0, false},
+		{`station glob "St. *"`, "St. John's", 0, true},
+		{`station glob "St.*"`, "Stuttgart", 0, false},
+		{`station glob "?amburg"`, "Hamburg", 0, true},
+		{`station glob "[!H]amburg"`, "Hamburg", 0, false},
+		{`station glob "[a-z]*"`, "abha", 0, true},
+		{`temp <= -0.5`, "a", -5, true},
+		{`temp <= -0.5`, "a", -4, false},
+		{`temperature > 10`, "a", 101, true},
+		{`temp >= 99.9`, "a", 999, true},
+		{`temp = 1.5`, "a", 15, true},
+		{`temp != 1.5`, "a", 15, false},
+		{`temp > 1000`, "a", 999, false},
+		{`not temp < 0`, "a", 0, true},
+		{`! (temp < 0 || temp > 10)`, "a", 100, true},
+		{`station = "a" or station = "b" and temp > 0`, "a", -1, true},
+		{`(station = "a" or station = "b") and temp > 0`, "a", -1, false},
+		{`STATION ~ "a" AND NOT temp < 0`, "a", 1, true},
+	} {
+		f, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := f.Match([]byte(tc.station), tc.temp); got != tc.match {
+			t.Errorf("%s: Match(%q, %d) expected: %v, got: %v", tc.expr, tc.station, tc.temp, tc.match, got)
+		}
+		if got := f.Station([]byte(tc.station)).Contains(tc.temp); got != tc.match {
+			t.Errorf("%s: Station(%q).Contains(%d) expected: %v, got: %v", tc.expr, tc.station, tc.temp, tc.match, got)
+		}
+	}
+}
+
+func TestStation(t *testing.T) {
+	f, err := Parse(`station ~ "^a" and (temp < -1.0 or temp > 1.0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := f.Station([]byte("abc"))
+	for temp := int64(stats.MinTemperature); temp <= stats.MaxTemperature; temp++ {
+		if got, expected := a.Contains(temp), f.Match([]byte("abc"), temp); got != expected {
+			t.Errorf("Contains(%d) expected: %v, got: %v", temp, expected, got)
+		}
+	}
+	if a.Contains(stats.MinTemperature-1) || a.Contains(stats.MaxTemperature+1) {
+		t.Errorf("Out of range temperatures must not be contained")
+	}
+	if f.Station([]byte("b")) != &none {
+		t.Errorf("Expected shared empty set")
+	}
+
+	f, err = Parse(`temp > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Station([]byte("a")) != f.Station([]byte("b")) {
+		t.Errorf("Expected the same set for expression without station conditions")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`station`,
+		`station < "a"`,
+		`station ~ "("`,
+		`station ~ a`,
+		`station in "a"`,
+		`station in ("a" "b")`,
+		`station not ~ "a"`,
+		`station glob "[a"`,
+		`temp < "a"`,
+		`temp < 1.25`,
+		`temp < 1.`,
+		`temp < -`,
+		`temp ~ 1`,
+		`temp < 1 and`,
+		`(temp < 1`,
+		`temp < 1)`,
+		`city = "a"`,
+		`station = "a" # comment`,
+		`station = "unterminated`,
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected error for %s", expr)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/yusukemorita/1brc/src/main/go/onebrc
+
+go 1.21.5
@@ -0,0 +1,221 @@
+// Package groupby aggregates results by keys derived from station names
+// selected by -group-by flag: name prefixes, regular expression captures or
+// a lookup in a CSV file, e.g. from station to country.
+//
+// Keys are derived from the aggregates of stations after processing instead
+// of from each record: aggregates are mergeable, so the results are the
+// same, a key is computed once per station instead of once per record and
+// processing without -group-by is not affected at all.
+package groupby
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+// Func returns the key of a station, stations without a key are dropped.
+type Func func(station string) (key string, ok bool)
+
+// Prefix returns a function that keys stations by the first n bytes of their
+// names, shortened so that a UTF-8 encoded character is not split.
+// Names shorter than n bytes are keys themselves.
+func Prefix(n int) Func {
+	return func(station string) (string, bool) {
+		if len(station) <= n {
+			return station, true
+		}
+		i := n
+		for i > 0 && !utf8.RuneStart(station[i]) {
+			i--
+		}
+		return station[:i], true
+	}
+}
+
+// Regexp returns a function that keys stations by the first submatch of re,
+// or by the whole match if re has no groups.
+// Stations that do not match are dropped.
+func Regexp(re *regexp.Regexp) Func {
+	group := 0
+	if re.NumSubexp() > 0 {
+		group = 1
+	}
+	return func(station string) (string, bool) {
+		m := re.FindStringSubmatchIndex(station)
+		if m == nil || m[2*group] < 0 {
+			return "", false
+		}
+		return station[m[2*group]:m[2*group+1]], true
+	}
+}
+
+// Lookup reads a CSV file with station names in the first column and returns
+// a function that keys stations by the given column, numbered from 1.
+// Columns are separated by ';' like in data/weather_stations.csv, or by ','
+// if the first record has no ';'. Lines starting with '#' are comments.
+// The first record of a station wins, stations that are not in the file or
+// have fewer columns are dropped.
+func Lookup(r io.Reader, column int) (Func, error) {
+	if column < 2 {
+		return nil, fmt.Errorf("invalid column %d, the first column is the station name", column)
+	}
+
+	br := bufio.NewReader(r)
+	cr := csv.NewReader(br)
+	cr.Comma = ';'
+	if !hasSemicolon(br) {
+		cr.Comma = ','
+	}
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	keys := make(map[string]string)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keys[record[0]]; ok || len(record) < column {
+			continue
+		}
+		keys[record[0]] = record[column-1]
+	}
+	return func(station string) (string, bool) {
+		key, ok := keys[station]
+		return key, ok
+	}, nil
+}
+
+// hasSemicolon reports whether the first line that is not a comment contains
+// ';' without consuming the reader.
+func hasSemicolon(br *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n * 4096)
+		for len(b) > 0 {
+			line, rest, found := bytes.Cut(b, []byte{'\n'})
+			if !found && err == nil {
+				break // line continues beyond peeked bytes
+			}
+			if len(line) > 0 && line[0] != '#' {
+				return bytes.IndexByte(line, ';') >= 0
+			}
+			b = rest
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// Parse returns a key function for spec:
+//   - prefix:N keys by the first N bytes, see Prefix
+//   - regex:EXPR keys by the first submatch of EXPR, see Regexp
+//   - csv:FILE[:COLUMN] keys by the column of FILE, the second by default, see Lookup
+func Parse(spec string) (Func, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "prefix":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid prefix length %q", arg)
+		}
+		return Prefix(n), nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return Regexp(re), nil
+	case "csv":
+		filename, column := arg, 2
+		if i := strings.LastIndexByte(arg, ':'); i >= 0 {
+			if n, err := strconv.Atoi(arg[i+1:]); err == nil {
+				filename, column = arg[:i], n
+			}
+		}
+		if filename == "" {
+			return nil, errors.New("missing CSV file")
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return Lookup(f, column)
+	}
+	return nil, fmt.Errorf("unknown group-by %q, expected prefix:N, regex:EXPR or csv:FILE[:COLUMN]", spec)
+}
+
+// Group merges aggregates of stations with the same key and returns them by
+// key with the number of dropped stations. It does not modify aggregates.
+func Group(aggregates map[string]*stats.Aggregate, key Func) (map[string]*stats.Aggregate, int) {
+	grouped := make(map[string]*stats.Aggregate)
+	dropped := 0
+	for station, a := range aggregates {
+		k, ok := key(station)
+		if !ok {
+			dropped++
+			continue
+		}
+		g, ok := grouped[k]
+		if !ok {
+			g = new(stats.Aggregate)
+			grouped[k] = g
+		}
+		g.Merge(a)
+	}
+	return grouped, dropped
+}
+
+// Config holds the -group-by spec, empty spec keeps station names.
+type Config struct {
+	Spec string
+
+	key Func
+}
+
+// RegisterFlags registers -group-by flag.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Spec, "group-by", "", "aggregate by `key` derived from station names: prefix:N, regex:EXPR or csv:FILE[:COLUMN]")
+}
+
+// Load parses the spec and reads the CSV file if any, so that errors are
+// reported before processing.
+func (c *Config) Load() error {
+	if c.Spec == "" {
+		return nil
+	}
+	var err error
+	c.key, err = Parse(c.Spec)
+	return err
+}
+
+// Apply returns aggregates grouped by the loaded key function, or aggregates
+// unchanged if there is none, and logs the number of dropped stations.
+func (c *Config) Apply(aggregates map[string]*stats.Aggregate) map[string]*stats.Aggregate {
+	if c.key == nil {
+		return aggregates
+	}
+	grouped, dropped := Group(aggregates, c.key)
+	if dropped > 0 {
+		log.Printf("Dropped %d stations without %s key", dropped, c.Spec)
+	}
+	return grouped
+}
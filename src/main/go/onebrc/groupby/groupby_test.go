@@ -0,0 +1,100 @@
+package groupby
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func TestKeys(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "stations.csv")
+	err := os.WriteFile(csvFile, []byte("# comment; with semicolon\nHamburg;53.5;DE\nBerlin;52.5;DE\nBulawayo;-20.2;ZW\nHamburg;0;XX\nShort;1\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		spec     string
+		station  string
+		key      string
+		expected bool
+	}{
+		{"prefix:3", "Hamburg", "Ham", true},
+		{"prefix:3", "Ha", "Ha", true},
+		{"prefix:2", "Ürümqi", "Ü", true},
+		{"prefix:1", "Ürümqi", "", true},
+		{"regex:^(\\S+)", "St. John's", "St.", true},
+		{"regex:[a-z]+$", "St. John's", "s", true},
+		{"regex:^(X)?", "Hamburg", "", false},
+		{"regex:^X", "Hamburg", "", false},
+		{"csv:" + csvFile + ":3", "Hamburg", "DE", true},
+		{"csv:" + csvFile + ":3", "Bulawayo", "ZW", true},
+		{"csv:" + csvFile + ":3", "Short", "", false},
+		{"csv:" + csvFile + ":3", "Abha", "", false},
+		{"csv:" + csvFile, "Hamburg", "53.5", true},
+	} {
+		key, err := Parse(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		if k, ok := key(tc.station); k != tc.key || ok != tc.expected {
+			t.Errorf("%s of %q, expected: %q %v, got: %q %v", tc.spec, tc.station, tc.key, tc.expected, k, ok)
+		}
+	}
+
+	for _, spec := range []string{"", "prefix:0", "prefix:x", "regex:(", "csv:", "csv:" + csvFile + ":1", "csv:missing.csv", "suffix:1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestLookupComma(t *testing.T) {
+	key, err := Lookup(strings.NewReader("#station,country\n\"Washington, D.C.\",US\n"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key("Washington, D.C."); k != "US" || !ok {
+		t.Errorf("Expected US, got: %q %v", k, ok)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	fields, err := stats.ParseFields("min,mean,max,count,median")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "Hamburg;12.0\nHalle;8.9\nHamburg;-3.4\nBerlin;15.2\nHalle;-0.5\n"
+	aggregates, err := stats.CheckFields(strings.NewReader(input), fields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := aggregates["Hamburg"].String()
+
+	grouped, dropped := Group(aggregates, Regexp(regexp.MustCompile("^Ha")))
+	if dropped != 1 {
+		t.Errorf("Expected 1 dropped station, got: %d", dropped)
+	}
+	var out strings.Builder
+	if err := stats.FormatFields(&out, grouped, fields); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{Ha=-3.4/4.3/12.0/4/-0.5}\n"; out.String() != expected {
+		t.Errorf("Wrong output, expected: %s, got: %s", expected, out.String())
+	}
+	if after := aggregates["Hamburg"].String(); after != before {
+		t.Errorf("Group modified aggregates: %s, expected: %s", after, before)
+	}
+
+	var c Config
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if g := c.Apply(aggregates); len(g) != len(aggregates) {
+		t.Errorf("Expected aggregates unchanged without spec")
+	}
+}
@@ -0,0 +1,53 @@
+package onebrctest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+// Fixture is a file of measurements of all names used by Measurements with
+// a few hundred different temperatures each, it is used to compare
+// statistics, histograms and filtered results of the solvers with
+// the reference.
+type Fixture struct {
+	Path string // file in a temporary directory of the test
+	Data []byte // contents of the file
+}
+
+// NewFixture writes the fixture file.
+func NewFixture(t testing.TB) *Fixture {
+	t.Helper()
+
+	data := Measurements(bytes.Repeat([]byte("0123456789abcdef"), 1000))
+	return &Fixture{Path: TempFile(t, data), Data: data}
+}
+
+// Reference returns aggregates created by newAggregate of the records for
+// which keep returns true, nil keep keeps all of them, see stats.CheckWhere.
+func (f *Fixture) Reference(t testing.TB, newAggregate func() *stats.Aggregate, keep func(name []byte, temp int64) bool) map[string]*stats.Aggregate {
+	t.Helper()
+
+	aggregates, err := stats.CheckWhere(bytes.NewReader(f.Data), newAggregate, keep, func(r *stats.InvalidRecord) {
+		t.Fatalf("Invalid fixture: %v", r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aggregates
+}
+
+// TempFile writes data to a file in a temporary directory of the test and
+// returns its path.
+func TempFile(t testing.TB, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
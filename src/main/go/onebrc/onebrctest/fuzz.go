@@ -0,0 +1,83 @@
+package onebrctest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+// names used by Measurements, including ones with multi-byte characters,
+// separators of the output format and the longest allowed name.
+var names = []string{
+	"a",
+	"Abha",
+	"Washington, D.C.",
+	"Ségou",
+	"Petropavlovsk-Kamchatsky",
+	"São Paulo=1.0/2.0",
+	"Canberra1️⃣🐝🏎️",
+	"x",
+	"id42",
+	strings.Repeat("Long", stats.MaxNameLen/4),
+	strings.Repeat("ж", stats.MaxNameLen/2),
+}
+
+// Measurements returns valid measurements built from arbitrary fuzz input,
+// each 3 bytes of seed produce a record.
+func Measurements(seed []byte) []byte {
+	var data bytes.Buffer
+	for ; len(seed) >= 3; seed = seed[3:] {
+		name := names[int(seed[0])%len(names)]
+		temp := int64(binary.LittleEndian.Uint16(seed[1:]))%(stats.MaxTemperature-stats.MinTemperature+1) + stats.MinTemperature
+
+		sign := ""
+		if temp < 0 {
+			sign = "-"
+		}
+		fmt.Fprintf(&data, "%s;%s%d.%d\n", name, sign, abs(temp)/10, abs(temp)%10)
+	}
+	return data.Bytes()
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Reference returns aggregates of valid measurements computed by
+// the straightforward stats.Check.
+func Reference(t testing.TB, data []byte) map[string]*stats.Aggregate {
+	t.Helper()
+
+	aggregates, err := stats.Check(bytes.NewReader(data), func(r *stats.InvalidRecord) {
+		t.Fatalf("Invalid reference input: %v", r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aggregates
+}
+
+// CompareAggregates reports stations with aggregates that differ.
+func CompareAggregates(t testing.TB, expected, actual map[string]*stats.Aggregate) {
+	t.Helper()
+
+	for name, e := range expected {
+		if a := actual[name]; a == nil {
+			t.Errorf("Missing %q: %+v", name, *e)
+		} else if *a != *e {
+			t.Errorf("Wrong %q, expected: %+v, got: %+v", name, *e, *a)
+		}
+	}
+	for name, a := range actual {
+		if expected[name] == nil {
+			t.Errorf("Unexpected %q: %+v", name, *a)
+		}
+	}
+}
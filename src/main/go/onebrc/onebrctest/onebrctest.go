@@ -0,0 +1,130 @@
+// Package onebrctest runs Go solvers against the challenge test samples and
+// the extra samples in testdata, i.e. measurements-*.txt files with expected
+// output in .out files, e.g.:
+//
+//	func TestSamples(t *testing.T) {
+//		onebrctest.RunSamples(t, func(t *testing.T, path string) []byte {
+//			var out bytes.Buffer
+//			printResults(&out, processFile(path))
+//			return out.Bytes()
+//		})
+//	}
+package onebrctest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+// SamplesDir is the directory with challenge test samples.
+var SamplesDir = filepath.Join(packageDir(), "../../../../test/resources/samples")
+
+// ExtraSamplesDir is the directory with samples that go beyond the challenge
+// rules but Go solvers must handle, e.g. names longer than 100 bytes.
+var ExtraSamplesDir = filepath.Join(packageDir(), "testdata")
+
+func packageDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}
+
+// Solver returns output of a solver for the measurements file at path.
+type Solver func(t *testing.T, path string) []byte
+
+// Samples returns measurements-*.txt sample files of SamplesDir and
+// ExtraSamplesDir.
+func Samples(t *testing.T) []string {
+	t.Helper()
+
+	var samples []string
+	for _, dir := range []string{SamplesDir, ExtraSamplesDir} {
+		matches, err := filepath.Glob(filepath.Join(dir, "measurements-*.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) == 0 {
+			t.Fatalf("No samples found in %s", dir)
+		}
+		samples = append(samples, matches...)
+	}
+	return samples
+}
+
+// RunSamples runs solve for each sample in a subtest named after the sample
+// and compares its output with the expected one.
+func RunSamples(t *testing.T, solve Solver) {
+	t.Helper()
+
+	for _, sample := range Samples(t) {
+		sample := sample
+		t.Run(strings.TrimSuffix(filepath.Base(sample), ".txt"), func(t *testing.T) {
+			expected, err := os.ReadFile(strings.TrimSuffix(sample, ".txt") + ".out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			Compare(t, expected, solve(t, sample))
+		})
+	}
+}
+
+// Compare reports differences between expected and actual outputs per station.
+func Compare(t *testing.T, expected, actual []byte) {
+	t.Helper()
+
+	want, err := stats.ParseResults(expected)
+	if err != nil {
+		t.Fatalf("Invalid expected output: %v", err)
+	}
+	got, err := stats.ParseResults(actual)
+	if err != nil {
+		t.Fatalf("Invalid output: %v", err)
+	}
+
+	if diff := Diff(want, got); diff != "" {
+		t.Errorf("Output differs (-expected +actual):\n%s", diff)
+	}
+}
+
+// Diff returns per station differences between expected and actual results,
+// or an empty string if there are none.
+func Diff(expected, actual []stats.Result) string {
+	var diff strings.Builder
+
+	actualByName := make(map[string]stats.Result, len(actual))
+	for _, r := range actual {
+		actualByName[r.Name] = r
+	}
+	expectedByName := make(map[string]bool, len(expected))
+	for _, e := range expected {
+		expectedByName[e.Name] = true
+
+		a, ok := actualByName[e.Name]
+		switch {
+		case !ok:
+			fmt.Fprintf(&diff, "-%s\n", e)
+		case a.String() != e.String():
+			fmt.Fprintf(&diff, "-%s\n+%s\n", e, a)
+		}
+	}
+	for _, a := range actual {
+		if !expectedByName[a.Name] {
+			fmt.Fprintf(&diff, "+%s\n", a)
+		}
+	}
+
+	if diff.Len() == 0 {
+		for i := range actual {
+			if actual[i].Name != expected[i].Name {
+				fmt.Fprintf(&diff, "stations are not sorted, expected %q at position %d, got %q\n", expected[i].Name, i, actual[i].Name)
+				break
+			}
+		}
+	}
+	return diff.String()
+}
@@ -0,0 +1,72 @@
+package onebrctest
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func TestDiff(t *testing.T) {
+	parse := func(s string) []stats.Result {
+		results, err := stats.ParseResults([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	for _, tc := range []struct {
+		expected, actual, diff string
+	}{
+		{
+			expected: "{Washington, D.C.=-15.1/14.8/44.8, Wau=-2.1/27.4/53.4}",
+			actual:   "{Washington, D.C.=-15.1/14.8/44.8, Wau=-2.1/27.4/53.4}\n",
+			diff:     "",
+		},
+		{
+			expected: "{a=1.0/2.0/3.0, b=1.0/2.0/3.0, c=1.0/2.0/3.0}",
+			actual:   "{a=1.0/2.0/3.0, c=1.0/2.1/3.0, d=0.0/0.0/0.0}",
+			diff:     "-b=1.0/2.0/3.0\n-c=1.0/2.0/3.0\n+c=1.0/2.1/3.0\n+d=0.0/0.0/0.0\n",
+		},
+		{
+			expected: "{a=1.0/2.0/3.0, b=1.0/2.0/3.0}",
+			actual:   "{b=1.0/2.0/3.0, a=1.0/2.0/3.0}",
+			diff:     "stations are not sorted, expected \"a\" at position 0, got \"b\"\n",
+		},
+		{
+			expected: "{}",
+			actual:   "{}",
+			diff:     "",
+		},
+	} {
+		if diff := Diff(parse(tc.expected), parse(tc.actual)); diff != tc.diff {
+			t.Errorf("Wrong diff of %s and %s, expected:\n%s\ngot:\n%s", tc.expected, tc.actual, tc.diff, diff)
+		}
+	}
+}
+
+func TestRunSamples(t *testing.T) {
+	// expected output passes, i.e. all .out files are parsed and sorted
+	RunSamples(t, func(t *testing.T, path string) []byte {
+		out, err := os.ReadFile(strings.TrimSuffix(path, ".txt") + ".out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	})
+}
+
+func FuzzMeasurements(f *testing.F) {
+	f.Add([]byte("seed"))
+	f.Add([]byte{0, 0xff, 0xff, 1, 0, 0, 9, 0xe7, 0x03})
+	f.Fuzz(func(t *testing.T, seed []byte) {
+		data := Measurements(seed)
+		if got := bytes.Count(data, []byte{'\n'}); got != len(seed)/3 {
+			t.Errorf("Expected %d records, got: %d", len(seed)/3, got)
+		}
+		Reference(t, data) // fails on invalid records
+	})
+}
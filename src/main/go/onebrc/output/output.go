@@ -0,0 +1,197 @@
+// Package output writes results of the solvers in formats selected by
+// -output-format flag: the challenge's canonical format, JSON, NDJSON,
+// CSV, TSV and Parquet, so that downstream tools do not have to re-parse
+// the canonical format.
+package output
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+// Writer writes results sorted by station name.
+type Writer interface {
+	WriteResults(w io.Writer, results map[string]*stats.Aggregate) error
+}
+
+// Formats are names of supported formats, see New.
+var Formats = []string{"canonical", "json", "json-object", "ndjson", "csv", "tsv", "parquet"}
+
+// New returns a writer of the fields of each station in the format:
+//   - canonical: {Abha=-23.0/18.0/59.2, ...} as expected by the challenge
+//   - json: array of objects, e.g. [{"station":"Abha","min":-23.0,"mean":18.0,"max":59.2}]
+//   - json-object: object keyed by station, e.g. {"Abha":{"min":-23.0,"mean":18.0,"max":59.2}}
+//   - ndjson: one JSON object per line, same as elements of json array
+//   - csv and tsv: header followed by one station per line
+//   - parquet: single row group with station column followed by one column per field
+//
+// Statistics that are not collected are written as NaN, null in JSON.
+func New(format string, fields stats.Fields) (Writer, error) {
+	switch format {
+	case "canonical":
+		return canonical{fields}, nil
+	case "json":
+		return jsonWriter{fields: fields}, nil
+	case "json-object":
+		return jsonWriter{fields: fields, object: true}, nil
+	case "ndjson":
+		return jsonWriter{fields: fields, lines: true}, nil
+	case "csv":
+		return delimited{fields: fields, comma: ','}, nil
+	case "tsv":
+		return delimited{fields: fields, comma: '\t'}, nil
+	case "parquet":
+		return parquet{fields}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected one of: %s", format, strings.Join(Formats, ", "))
+}
+
+// Config selects output format and file.
+type Config struct {
+	Format string
+	File   string
+}
+
+// RegisterFlags registers -output-format and -output flags.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Format, "output-format", "canonical", "results `format`: "+strings.Join(Formats, ", "))
+	fs.StringVar(&c.File, "output", "", "write results to `file` instead of stdout")
+}
+
+// Check returns an error if the format is unknown, so that it is reported
+// before processing.
+func (c *Config) Check() error {
+	_, err := New(c.Format, nil)
+	return err
+}
+
+// Write writes the fields of results in the configured format to the file
+// or stdout.
+func (c *Config) Write(results map[string]*stats.Aggregate, fields stats.Fields) error {
+	w, err := New(c.Format, fields)
+	if err != nil {
+		return err
+	}
+	if c.File == "" {
+		return w.WriteResults(os.Stdout, results)
+	}
+
+	f, err := os.Create(c.File)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteResults(f, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type canonical struct {
+	fields stats.Fields
+}
+
+func (c canonical) WriteResults(w io.Writer, results map[string]*stats.Aggregate) error {
+	return stats.FormatFields(w, results, c.fields)
+}
+
+type jsonWriter struct {
+	fields stats.Fields
+	object bool // object keyed by station instead of array
+	lines  bool // newline delimited objects
+}
+
+func (j jsonWriter) WriteResults(w io.Writer, results map[string]*stats.Aggregate) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+
+	switch {
+	case j.object:
+		bw.WriteByte('{')
+	case !j.lines:
+		bw.WriteByte('[')
+	}
+	for i, name := range stats.SortedNames(results) {
+		station, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+
+		buf = buf[:0]
+		switch {
+		case j.lines:
+		case i > 0:
+			buf = append(buf, ',')
+		}
+		if j.object {
+			buf = append(append(buf, station...), ":{"...)
+		} else {
+			buf = append(append(append(buf, `{"station":`...), station...), ',')
+		}
+		for k, f := range j.fields {
+			if k > 0 {
+				buf = append(buf, ',')
+			}
+			key, err := json.Marshal(f.Name)
+			if err != nil {
+				return err
+			}
+			buf = append(append(buf, key...), ':')
+			if math.IsNaN(f.Value(results[name])) {
+				buf = append(buf, "null"...)
+			} else {
+				buf = f.AppendText(buf, results[name])
+			}
+		}
+		buf = append(buf, '}')
+		if j.lines {
+			buf = append(buf, '\n')
+		}
+		bw.Write(buf)
+	}
+	switch {
+	case j.object:
+		bw.WriteString("}\n")
+	case !j.lines:
+		bw.WriteString("]\n")
+	}
+	return bw.Flush()
+}
+
+type delimited struct {
+	fields stats.Fields
+	comma  rune
+}
+
+func (d delimited) WriteResults(w io.Writer, results map[string]*stats.Aggregate) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = d.comma
+
+	record := make([]string, 1+len(d.fields))
+	record[0] = "station"
+	for i, f := range d.fields {
+		record[1+i] = f.Name
+	}
+	cw.Write(record)
+
+	var buf []byte
+	for _, name := range stats.SortedNames(results) {
+		record[0] = name
+		for i, f := range d.fields {
+			buf = f.AppendText(buf[:0], results[name])
+			record[1+i] = string(buf)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,102 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func testResults(t *testing.T, fields stats.Fields) map[string]*stats.Aggregate {
+	t.Helper()
+
+	results, err := stats.CheckFields(strings.NewReader("Hamburg;12.0\nBulawayo;8.9\nHamburg;-3.4\nSt. John's;15.2\n\"Quoted\", Town;-0.5\n"), fields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestWriters(t *testing.T) {
+	fields, err := stats.ParseFields("min,mean,max,count,stddev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stddev is not collected by aggregates of default fields
+	results := testResults(t, stats.DefaultFields)
+
+	for _, tc := range []struct {
+		format, expected string
+	}{
+		{"canonical", `{"Quoted", Town=-0.5/-0.5/-0.5/1/NaN, Bulawayo=8.9/8.9/8.9/1/NaN, Hamburg=-3.4/4.3/12.0/2/NaN, St. John's=15.2/15.2/15.2/1/NaN}` + "\n"},
+		{"json", `[{"station":"\"Quoted\", Town","min":-0.5,"mean":-0.5,"max":-0.5,"count":1,"stddev":null},` +
+			`{"station":"Bulawayo","min":8.9,"mean":8.9,"max":8.9,"count":1,"stddev":null},` +
+			`{"station":"Hamburg","min":-3.4,"mean":4.3,"max":12.0,"count":2,"stddev":null},` +
+			`{"station":"St. John's","min":15.2,"mean":15.2,"max":15.2,"count":1,"stddev":null}]` + "\n"},
+		{"json-object", `{"\"Quoted\", Town":{"min":-0.5,"mean":-0.5,"max":-0.5,"count":1,"stddev":null},` +
+			`"Bulawayo":{"min":8.9,"mean":8.9,"max":8.9,"count":1,"stddev":null},` +
+			`"Hamburg":{"min":-3.4,"mean":4.3,"max":12.0,"count":2,"stddev":null},` +
+			`"St. John's":{"min":15.2,"mean":15.2,"max":15.2,"count":1,"stddev":null}}` + "\n"},
+		{"ndjson", `{"station":"\"Quoted\", Town","min":-0.5,"mean":-0.5,"max":-0.5,"count":1,"stddev":null}` + "\n" +
+			`{"station":"Bulawayo","min":8.9,"mean":8.9,"max":8.9,"count":1,"stddev":null}` + "\n" +
+			`{"station":"Hamburg","min":-3.4,"mean":4.3,"max":12.0,"count":2,"stddev":null}` + "\n" +
+			`{"station":"St. John's","min":15.2,"mean":15.2,"max":15.2,"count":1,"stddev":null}` + "\n"},
+		{"csv", "station,min,mean,max,count,stddev\n" +
+			`"""Quoted"", Town",-0.5,-0.5,-0.5,1,NaN` + "\n" +
+			"Bulawayo,8.9,8.9,8.9,1,NaN\nHamburg,-3.4,4.3,12.0,2,NaN\nSt. John's,15.2,15.2,15.2,1,NaN\n"},
+		{"tsv", "station\tmin\tmean\tmax\tcount\tstddev\n" +
+			"\"\"\"Quoted\"\", Town\"\t-0.5\t-0.5\t-0.5\t1\tNaN\n" +
+			"Bulawayo\t8.9\t8.9\t8.9\t1\tNaN\nHamburg\t-3.4\t4.3\t12.0\t2\tNaN\nSt. John's\t15.2\t15.2\t15.2\t1\tNaN\n"},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			w, err := New(tc.format, fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err := w.WriteResults(&out, results); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("Wrong output, expected:\n%s\ngot:\n%s", tc.expected, out.String())
+			}
+
+			if strings.HasPrefix(tc.format, "json") && !json.Valid(out.Bytes()) {
+				t.Errorf("Invalid JSON")
+			}
+		})
+	}
+}
+
+func TestWritersEmpty(t *testing.T) {
+	for _, format := range Formats {
+		w, err := New(format, stats.DefaultFields)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		if err := w.WriteResults(&out, nil); err != nil {
+			t.Errorf("Failed to write %s: %v", format, err)
+		}
+		if strings.HasPrefix(format, "json") && !json.Valid(out.Bytes()) {
+			t.Errorf("Invalid empty %s: %s", format, out.String())
+		}
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	for _, format := range Formats {
+		c := Config{Format: format}
+		if err := c.Check(); err != nil {
+			t.Errorf("Unexpected error for %s: %v", format, err)
+		}
+	}
+	c := Config{Format: "xml"}
+	if err := c.Check(); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
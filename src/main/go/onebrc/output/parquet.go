@@ -0,0 +1,230 @@
+package output
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+// parquet writes results as an uncompressed Parquet file with a single row
+// group and a single PLAIN encoded data page per column, see
+// https://github.com/apache/parquet-format. All columns are required:
+// station is a UTF8 BYTE_ARRAY, count is a UINT_64 INT64 and other fields
+// are DOUBLE. The output is checked by an independent reader,
+// see TestParquet.
+type parquet struct {
+	fields stats.Fields
+}
+
+// Parquet physical types, converted types and encodings used by the writer.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8   = 0
+	parquetUint64 = 14
+
+	parquetRequired = 0
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+type parquetColumn struct {
+	name          string
+	typ           int32
+	convertedType int32 // -1 if none
+	data          []byte
+}
+
+func (p parquet) WriteResults(w io.Writer, results map[string]*stats.Aggregate) error {
+	names := stats.SortedNames(results)
+
+	columns := []parquetColumn{{name: "station", typ: parquetByteArray, convertedType: parquetUTF8}}
+	for _, f := range p.fields {
+		if f.Name == "count" {
+			columns = append(columns, parquetColumn{name: f.Name, typ: parquetInt64, convertedType: parquetUint64})
+		} else {
+			columns = append(columns, parquetColumn{name: f.Name, typ: parquetDouble, convertedType: -1})
+		}
+	}
+	for _, name := range names {
+		a := results[name]
+		columns[0].data = binary.LittleEndian.AppendUint32(columns[0].data, uint32(len(name)))
+		columns[0].data = append(columns[0].data, name...)
+		for i, f := range p.fields {
+			c := &columns[1+i]
+			if c.typ == parquetInt64 {
+				c.data = binary.LittleEndian.AppendUint64(c.data, a.Count)
+			} else {
+				c.data = binary.LittleEndian.AppendUint64(c.data, math.Float64bits(f.Value(a)))
+			}
+		}
+	}
+
+	file := []byte("PAR1")
+	var chunks compact
+	var totalSize int64
+	chunks.listHeader(len(columns), compactStruct)
+	for _, c := range columns {
+		offset := int64(len(file))
+
+		var page compact
+		page.structBegin()
+		page.i32Field(1, 0) // DATA_PAGE
+		page.i32Field(2, int32(len(c.data)))
+		page.i32Field(3, int32(len(c.data)))
+		page.fieldHeader(5, compactStruct) // DataPageHeader
+		page.structBegin()
+		page.i32Field(1, int32(len(names)))
+		page.i32Field(2, parquetPlain)
+		page.i32Field(3, parquetRLE) // definition levels, omitted as columns are required
+		page.i32Field(4, parquetRLE) // repetition levels
+		page.structEnd()
+		page.structEnd()
+
+		file = append(file, page.b...)
+		file = append(file, c.data...)
+		size := int64(len(file)) - offset
+		totalSize += size
+
+		// ColumnChunk
+		chunks.structBegin()
+		chunks.i64Field(2, offset)
+		chunks.fieldHeader(3, compactStruct) // ColumnMetaData
+		chunks.structBegin()
+		chunks.i32Field(1, c.typ)
+		chunks.fieldHeader(2, compactList)
+		chunks.listHeader(2, compactI32)
+		chunks.varint(zigzag(parquetPlain))
+		chunks.varint(zigzag(parquetRLE))
+		chunks.fieldHeader(3, compactList)
+		chunks.listHeader(1, compactBinary)
+		chunks.binary([]byte(c.name))
+		chunks.i32Field(4, 0) // UNCOMPRESSED
+		chunks.i64Field(5, int64(len(names)))
+		chunks.i64Field(6, size)
+		chunks.i64Field(7, size)
+		chunks.i64Field(9, offset)
+		chunks.structEnd()
+		chunks.structEnd()
+	}
+
+	// FileMetaData
+	var meta compact
+	meta.structBegin()
+	meta.i32Field(1, 1) // version
+	meta.fieldHeader(2, compactList)
+	meta.listHeader(1+len(columns), compactStruct)
+	meta.structBegin() // root
+	meta.binaryField(4, []byte("schema"))
+	meta.i32Field(5, int32(len(columns)))
+	meta.structEnd()
+	for _, c := range columns {
+		meta.structBegin()
+		meta.i32Field(1, c.typ)
+		meta.i32Field(3, parquetRequired)
+		meta.binaryField(4, []byte(c.name))
+		if c.convertedType >= 0 {
+			meta.i32Field(6, c.convertedType)
+		}
+		meta.structEnd()
+	}
+	meta.i64Field(3, int64(len(names)))
+	meta.fieldHeader(4, compactList)
+	meta.listHeader(1, compactStruct)
+	meta.structBegin() // RowGroup
+	meta.fieldHeader(1, compactList)
+	meta.b = append(meta.b, chunks.b...)
+	meta.i64Field(2, totalSize)
+	meta.i64Field(3, int64(len(names)))
+	meta.structEnd()
+	meta.binaryField(6, []byte("onebrc"))
+	meta.structEnd()
+
+	file = append(file, meta.b...)
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(meta.b)))
+	file = append(file, "PAR1"...)
+
+	_, err := w.Write(file)
+	return err
+}
+
+// Thrift compact protocol types.
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compact encodes Parquet metadata using Thrift compact protocol,
+// see https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md.
+// Fields of a struct must be written in ascending order of their ids.
+type compact struct {
+	b       []byte
+	lastID  int16
+	lastIDs []int16
+}
+
+func (c *compact) structBegin() {
+	c.lastIDs = append(c.lastIDs, c.lastID)
+	c.lastID = 0
+}
+
+func (c *compact) structEnd() {
+	c.b = append(c.b, 0) // stop
+	c.lastID = c.lastIDs[len(c.lastIDs)-1]
+	c.lastIDs = c.lastIDs[:len(c.lastIDs)-1]
+}
+
+func (c *compact) fieldHeader(id int16, typ byte) {
+	if delta := id - c.lastID; delta > 0 && delta <= 15 {
+		c.b = append(c.b, byte(delta)<<4|typ)
+	} else {
+		c.b = append(c.b, typ)
+		c.varint(zigzag(int64(id)))
+	}
+	c.lastID = id
+}
+
+func (c *compact) listHeader(size int, elemType byte) {
+	if size < 15 {
+		c.b = append(c.b, byte(size)<<4|elemType)
+	} else {
+		c.b = append(c.b, 0xf0|elemType)
+		c.varint(uint64(size))
+	}
+}
+
+func (c *compact) i32Field(id int16, v int32) {
+	c.fieldHeader(id, compactI32)
+	c.varint(zigzag(int64(v)))
+}
+
+func (c *compact) i64Field(id int16, v int64) {
+	c.fieldHeader(id, compactI64)
+	c.varint(zigzag(v))
+}
+
+func (c *compact) binaryField(id int16, v []byte) {
+	c.fieldHeader(id, compactBinary)
+	c.binary(v)
+}
+
+func (c *compact) binary(v []byte) {
+	c.varint(uint64(len(v)))
+	c.b = append(c.b, v...)
+}
+
+func (c *compact) varint(v uint64) {
+	c.b = binary.AppendUvarint(c.b, v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,93 @@
+package output
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// TestParquet compares the output with a golden file that was read back by
+// parquet-go, results.parquet.txt is its schema and rows printed by
+// testdata/parquetcheck. After changing the writer regenerate both files:
+//
+//	go test -run TestParquet -update
+//	cd testdata/parquetcheck && go run . ../results.parquet > ../results.parquet.txt
+func TestParquet(t *testing.T) {
+	fields, err := stats.ParseFields("min,mean,max,count,stddev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stddev is not collected by aggregates of default fields
+	results := testResults(t, stats.DefaultFields)
+	for i := 0; i < 20; i++ { // more than 14 stations need long list header
+		results[fmt.Sprintf("Station %02d", i)] = &stats.Aggregate{Min: int64(i), Max: int64(i), Sum: int64(i), Count: 1}
+	}
+
+	var out bytes.Buffer
+	if err := (parquet{fields}).WriteResults(&out, results); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "results.parquet")
+	if *update {
+		if err := os.WriteFile(golden, out.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("Output differs from %s, see TestParquet to update it", golden)
+	}
+
+	// the rows read by parquet-go are the results
+	dump, err := os.ReadFile(golden + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, rows, _ := strings.Cut(string(dump), "}\n")
+	if expected := parquetSchema(fields); schema+"}" != expected {
+		t.Errorf("Wrong schema, expected:\n%s\ngot:\n%s}", expected, schema)
+	}
+	var expectedRows strings.Builder
+	for _, name := range stats.SortedNames(results) {
+		expectedRows.WriteString(strconv.Quote(name))
+		for _, f := range fields {
+			if f.Name == "count" {
+				fmt.Fprintf(&expectedRows, "\t%d", results[name].Count)
+			} else {
+				fmt.Fprintf(&expectedRows, "\t%s", strconv.FormatFloat(f.Value(results[name]), 'g', -1, 64))
+			}
+		}
+		expectedRows.WriteByte('\n')
+	}
+	if rows != expectedRows.String() {
+		t.Errorf("Wrong rows, expected:\n%s\ngot:\n%s", expectedRows.String(), rows)
+	}
+}
+
+// parquetSchema returns the schema of fields as printed by parquet-go.
+func parquetSchema(fields stats.Fields) string {
+	var b strings.Builder
+	b.WriteString("message schema {\n\trequired binary station (STRING);\n")
+	for _, f := range fields {
+		if f.Name == "count" {
+			fmt.Fprintf(&b, "\trequired int64 %s (INT(64,false));\n", f.Name)
+		} else {
+			fmt.Fprintf(&b, "\trequired double %s;\n", f.Name)
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
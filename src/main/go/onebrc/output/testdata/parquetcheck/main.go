@@ -0,0 +1,81 @@
+// Command parquetcheck reads a Parquet file with parquet-go, an independent
+// implementation of the format, and prints its schema and rows, e.g.:
+//
+//	go run . ../results.parquet > ../results.parquet.txt
+//
+// It is a separate module so that the output package and the solvers do not
+// depend on parquet-go.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("Usage: %s <file.parquet>", os.Args[0])
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		log.Fatalf("Open: %v", err)
+	}
+	fmt.Println(pf.Schema())
+
+	rows := parquet.NewReader(pf)
+	defer rows.Close()
+	buf := make([]parquet.Row, 1)
+	for n := int64(0); ; {
+		read, err := rows.ReadRows(buf)
+		for _, row := range buf[:read] {
+			fmt.Println(formatRow(row, pf.Schema()))
+		}
+		n += int64(read)
+
+		if errors.Is(err, io.EOF) {
+			if n != pf.NumRows() {
+				log.Fatalf("Read %d rows, expected: %d", n, pf.NumRows())
+			}
+			return
+		}
+		if err != nil {
+			log.Fatalf("Read row %d: %v", n, err)
+		}
+	}
+}
+
+// formatRow formats values separated by tabs, doubles in the shortest
+// representation and counts as unsigned integers.
+func formatRow(row parquet.Row, schema *parquet.Schema) string {
+	values := make([]string, len(row))
+	for i, v := range row {
+		switch v.Kind() {
+		case parquet.ByteArray:
+			values[i] = strconv.Quote(v.String())
+		case parquet.Int64:
+			values[i] = strconv.FormatUint(v.Uint64(), 10)
+		case parquet.Double:
+			values[i] = strconv.FormatFloat(v.Double(), 'g', -1, 64)
+		default:
+			log.Fatalf("Unexpected %v value of column %s", v.Kind(), schema.Fields()[i].Name())
+		}
+	}
+	return strings.Join(values, "\t")
+}
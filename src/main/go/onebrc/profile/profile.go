@@ -0,0 +1,119 @@
+// Package profile writes the runtime profiles of a command, e.g.
+//
+//	var profiles profile.Config
+//	profiles.RegisterFlags(flag.CommandLine)
+//	flag.Parse()
+//	defer profiles.Start()()
+//
+// Profiling is disabled unless requested, e.g. with -cpuprofile=cpu.prof,
+// see go tool pprof and go tool trace.
+package profile
+
+import (
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+// Config holds profile file names, empty name disables the profile.
+type Config struct {
+	CPU, Memory, Block, Mutex, Trace string
+}
+
+// RegisterFlags registers -cpuprofile, -memprofile, -blockprofile,
+// -mutexprofile and -trace flags.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.CPU, "cpuprofile", "", "write cpu profile to `file`")
+	fs.StringVar(&c.Memory, "memprofile", "", "write memory profile to `file`")
+	fs.StringVar(&c.Block, "blockprofile", "", "write goroutine blocking profile to `file`")
+	fs.StringVar(&c.Mutex, "mutexprofile", "", "write mutex contention profile to `file`")
+	fs.StringVar(&c.Trace, "trace", "", "write execution trace to `file`")
+}
+
+// Enabled reports whether any profile is requested.
+func (c *Config) Enabled() bool {
+	return *c != Config{}
+}
+
+// Start starts requested profiles and returns a function that stops them,
+// writes the remaining profiles and reports the total duration to stderr.
+func (c *Config) Start() (stop func()) {
+	if !c.Enabled() {
+		return func() {}
+	}
+
+	startTime := time.Now()
+	var stops []func()
+
+	if c.CPU != "" {
+		f := create(c.CPU)
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("could not start CPU profile: %v", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			closeFile(f)
+		})
+	}
+
+	if c.Trace != "" {
+		f := create(c.Trace)
+		if err := trace.Start(f); err != nil {
+			log.Fatalf("could not start trace: %v", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			closeFile(f)
+		})
+	}
+
+	if c.Block != "" {
+		runtime.SetBlockProfileRate(1)
+		stops = append(stops, func() { write("block", c.Block) })
+	}
+
+	if c.Mutex != "" {
+		runtime.SetMutexProfileFraction(1)
+		stops = append(stops, func() { write("mutex", c.Mutex) })
+	}
+
+	if c.Memory != "" {
+		stops = append(stops, func() {
+			runtime.GC() // get up-to-date statistics
+			write("heap", c.Memory)
+		})
+	}
+
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+		log.Printf("total duration: %f seconds", time.Since(startTime).Seconds())
+	}
+}
+
+func write(name, filename string) {
+	f := create(filename)
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		log.Fatalf("could not write %s profile: %v", name, err)
+	}
+	closeFile(f)
+}
+
+func create(filename string) *os.File {
+	f, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("could not create profile: %v", err)
+	}
+	return f
+}
+
+func closeFile(f *os.File) {
+	if err := f.Close(); err != nil {
+		log.Fatalf("could not close profile: %v", err)
+	}
+}
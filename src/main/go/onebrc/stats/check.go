@@ -0,0 +1,170 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Limits of valid input, see the challenge rules.
+const (
+	MaxNameLen     = 100
+	MaxStations    = 10_000
+	MinTemperature = -999
+	MaxTemperature = 999
+)
+
+// InvalidRecord describes a malformed record found by Check.
+type InvalidRecord struct {
+	Line   int64 // 1-based line number
+	Offset int64 // offset of the first byte of the record
+	Reason string
+	Record []byte // record without the new line, \n or \r\n
+}
+
+func (r *InvalidRecord) Error() string {
+	const maxQuoted = 120
+	record := r.Record
+	if len(record) > maxQuoted {
+		record = record[:maxQuoted]
+	}
+	return fmt.Sprintf("line %d, offset %d: %s: %q", r.Line, r.Offset, r.Reason, record)
+}
+
+// Check reads records from r, validating each one, and returns aggregates
+// of valid records. Invalid records are passed to onInvalid and skipped,
+// the record passed to onInvalid is only valid until it returns.
+//
+// Unlike the solvers it does not assume valid input and is much slower,
+// it is meant to diagnose the input. Records end with \n or \r\n, the last
+// one may have no new line. A record is invalid if it has no ';',
+// the name is empty, longer than MaxNameLen bytes or not valid UTF-8,
+// the temperature does not match -?[0-9]{1,2}[.][0-9] pattern or
+// the name is a new station beyond MaxStations.
+func Check(r io.Reader, onInvalid func(*InvalidRecord)) (map[string]*Aggregate, error) {
+	return CheckFields(r, DefaultFields, onInvalid)
+}
+
+// CheckFields is like Check but creates aggregates that collect extra
+// statistics if the fields need them, see Fields.NewAggregate.
+func CheckFields(r io.Reader, fields Fields, onInvalid func(*InvalidRecord)) (map[string]*Aggregate, error) {
+	return CheckWith(r, fields.NewAggregate, onInvalid)
+}
+
+// CheckWith is like Check but creates aggregates by newAggregate.
+func CheckWith(r io.Reader, newAggregate func() *Aggregate, onInvalid func(*InvalidRecord)) (map[string]*Aggregate, error) {
+	return CheckWhere(r, newAggregate, nil, onInvalid)
+}
+
+// CheckWhere is like CheckWith but aggregates only valid records for which
+// keep returns true, nil keep keeps all of them.
+func CheckWhere(r io.Reader, newAggregate func() *Aggregate, keep func(name []byte, temp int64) bool, onInvalid func(*InvalidRecord)) (map[string]*Aggregate, error) {
+	return CheckLimits(r, newAggregate, keep, ChallengeLimits, onInvalid)
+}
+
+// Limits are limits of valid input, zero value does not limit it.
+type Limits struct {
+	MaxNameLen  int // bytes
+	MaxStations int
+}
+
+// ChallengeLimits are limits of the challenge rules checked by Check.
+var ChallengeLimits = Limits{MaxNameLen: MaxNameLen, MaxStations: MaxStations}
+
+// CheckLimits is like CheckWhere but checks limits instead of ChallengeLimits,
+// e.g. to check input of solvers that accept more stations or longer names.
+func CheckLimits(r io.Reader, newAggregate func() *Aggregate, keep func(name []byte, temp int64) bool, limits Limits, onInvalid func(*InvalidRecord)) (map[string]*Aggregate, error) {
+	aggregates := make(map[string]*Aggregate)
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	var long []byte
+	var line, offset int64
+	for {
+		record, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			long = append(long[:0], record...)
+			for err == bufio.ErrBufferFull {
+				record, err = br.ReadSlice('\n')
+				long = append(long, record...)
+			}
+			record = long
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(record) == 0 {
+			return aggregates, nil
+		}
+
+		line++
+		size := int64(len(record))
+		record = bytes.TrimSuffix(record, []byte{'\n'})
+		record = bytes.TrimSuffix(record, []byte{'\r'})
+
+		if name, temp, reason := checkRecord(record, limits.MaxNameLen); reason != "" {
+			onInvalid(&InvalidRecord{Line: line, Offset: offset, Reason: reason, Record: record})
+		} else if keep != nil && !keep(name, temp) {
+			// filtered out
+		} else if a := aggregates[string(name)]; a != nil {
+			a.Add(temp)
+		} else if limits.MaxStations > 0 && len(aggregates) >= limits.MaxStations {
+			onInvalid(&InvalidRecord{Line: line, Offset: offset, Reason: fmt.Sprintf("more than %d stations", limits.MaxStations), Record: record})
+		} else {
+			a = newAggregate()
+			a.Add(temp)
+			aggregates[string(name)] = a
+		}
+
+		offset += size
+		if err == io.EOF {
+			return aggregates, nil
+		}
+	}
+}
+
+func checkRecord(record []byte, maxNameLen int) (name []byte, temp int64, reason string) {
+	semiPos := bytes.IndexByte(record, ';')
+	if semiPos == -1 {
+		return nil, 0, "missing ';'"
+	}
+
+	name, value := record[:semiPos], record[semiPos+1:]
+	switch {
+	case len(name) == 0:
+		return nil, 0, "empty name"
+	case maxNameLen > 0 && len(name) > maxNameLen:
+		return nil, 0, fmt.Sprintf("name longer than %d bytes", maxNameLen)
+	case !utf8.Valid(name):
+		return nil, 0, "invalid UTF-8 name"
+	case !ValidTemperature(value):
+		if f, err := strconv.ParseFloat(string(value), 64); err == nil && (f < MinTemperature/10.0 || f > MaxTemperature/10.0) {
+			return nil, 0, "temperature out of range"
+		}
+		return nil, 0, "invalid temperature"
+	}
+	return name, ParseTemperature(value), ""
+}
+
+// ValidTemperature reports whether value matches -?[0-9]{1,2}[.][0-9] pattern
+// accepted by ParseTemperature.
+func ValidTemperature(value []byte) bool {
+	if len(value) > 0 && value[0] == '-' {
+		value = value[1:]
+	}
+	if len(value) != 3 && len(value) != 4 {
+		return false
+	}
+	for i, b := range value {
+		if i == len(value)-2 {
+			if b != '.' {
+				return false
+			}
+		} else if b < '0' || b > '9' {
+			return false
+		}
+	}
+	return true
+}
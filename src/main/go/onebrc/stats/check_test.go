@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	long := strings.Repeat("x", MaxNameLen)
+	input := "Hamburg;12.0\n" +
+		"Bulawayo\n" + // line 2, offset 13
+		";1.0\n" + // line 3, offset 22
+		long + "y;1.0\n" + // line 4, offset 27
+		long + ";-1.5\n" + // line 5, offset 133
+		"Bad\xffname;1.0\n" + // line 6, offset 239
+		"Hamburg;100.0\n" + // line 7, offset 252
+		"Hamburg;1.23\n" + // line 8, offset 266
+		"Hamburg;1,2\n" + // line 9, offset 279
+		"Hamburg;\n" + // line 10, offset 291
+		"Hamburg;-3.4;\n" + // line 11, offset 300
+		"\n" + // line 12, offset 314
+		"Hamburg;-3.4" // line 13, offset 315, no new line
+
+	var invalid []string
+	aggregates, err := Check(strings.NewReader(input), func(r *InvalidRecord) {
+		invalid = append(invalid, fmt.Sprintf("%d/%d: %s", r.Line, r.Offset, r.Reason))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"2/13: missing ';'",
+		"3/22: empty name",
+		"4/27: name longer than 100 bytes",
+		"6/239: invalid UTF-8 name",
+		"7/252: temperature out of range",
+		"8/266: invalid temperature",
+		"9/279: invalid temperature",
+		"10/291: invalid temperature",
+		"11/300: invalid temperature",
+		"12/314: missing ';'",
+	}
+	if got := strings.Join(invalid, "\n"); got != strings.Join(expected, "\n") {
+		t.Errorf("Wrong invalid records, expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), got)
+	}
+
+	var out strings.Builder
+	Format(&out, aggregates)
+	if want := "{Hamburg=-3.4/4.3/12.0, " + long + "=-1.5/-1.5/-1.5}\n"; out.String() != want {
+		t.Errorf("Expected %s, got %s", want, out.String())
+	}
+}
+
+func TestCheckMaxStations(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i <= MaxStations; i++ {
+		fmt.Fprintf(&input, "s%d;1.0\n", i)
+	}
+	input.WriteString("s0;2.0\n")
+
+	var invalid []*InvalidRecord
+	aggregates, err := Check(strings.NewReader(input.String()), func(r *InvalidRecord) {
+		invalid = append(invalid, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aggregates) != MaxStations {
+		t.Errorf("Expected %d stations, got %d", MaxStations, len(aggregates))
+	}
+	if len(invalid) != 1 || invalid[0].Line != MaxStations+1 {
+		t.Errorf("Expected station on line %d to be invalid, got: %v", MaxStations+1, invalid)
+	}
+	if a := aggregates["s0"]; a.Count != 2 {
+		t.Errorf("Expected 2 measurements of existing station, got: %d", a.Count)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i <= MaxStations; i++ {
+		fmt.Fprintf(&input, "s%d;1.0\n", i)
+	}
+	long := strings.Repeat("x", MaxNameLen+1)
+	input.WriteString(long + ";2.0\n")
+
+	for _, tc := range []struct {
+		limits   Limits
+		stations int
+		invalid  int
+	}{
+		{ChallengeLimits, MaxStations, 2},
+		{Limits{MaxNameLen: MaxNameLen}, MaxStations + 1, 1},
+		{Limits{MaxStations: MaxStations + 2}, MaxStations + 2, 0},
+		{Limits{}, MaxStations + 2, 0},
+	} {
+		invalid := 0
+		aggregates, err := CheckLimits(strings.NewReader(input.String()), DefaultFields.NewAggregate, nil, tc.limits, func(r *InvalidRecord) {
+			invalid++
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(aggregates) != tc.stations || invalid != tc.invalid {
+			t.Errorf("%+v: expected %d stations and %d invalid records, got: %d and %d", tc.limits, tc.stations, tc.invalid, len(aggregates), invalid)
+		}
+	}
+}
+
+func TestCheckLongRecord(t *testing.T) {
+	record := strings.Repeat("x", 100_000) + ";1.0\n"
+
+	var invalid []*InvalidRecord
+	_, err := Check(strings.NewReader("a;1.0\n"+record+"b;2.0\n"), func(r *InvalidRecord) {
+		invalid = append(invalid, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || invalid[0].Line != 2 || invalid[0].Offset != 6 || len(invalid[0].Record) != len(record)-1 {
+		t.Errorf("Expected long record on line 2 to be invalid, got: %v", invalid)
+	}
+}
+
+func TestCheckWhere(t *testing.T) {
+	input := "Hamburg;12.0\nBerlin;-1.0\nHamburg;-3.4\nbad\nBerlin;2.0\n"
+
+	invalid := 0
+	aggregates, err := CheckWhere(strings.NewReader(input), DefaultFields.NewAggregate, func(name []byte, temp int64) bool {
+		return string(name) == "Hamburg" || temp > 0
+	}, func(*InvalidRecord) { invalid++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid != 1 {
+		t.Errorf("Expected 1 invalid record, got: %d", invalid)
+	}
+
+	var out strings.Builder
+	Format(&out, aggregates)
+	if want := "{Berlin=2.0/2.0/2.0, Hamburg=-3.4/4.3/12.0}\n"; out.String() != want {
+		t.Errorf("Expected %s, got %s", want, out.String())
+	}
+}
+
+func TestCheckCRLF(t *testing.T) {
+	invalid := 0
+	aggregates, err := Check(strings.NewReader("Hamburg;12.0\r\nBerlin;-1.0\r\n\r\nHamburg;-3.4"), func(*InvalidRecord) { invalid++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid != 1 {
+		t.Errorf("Expected 1 invalid record, got: %d", invalid)
+	}
+
+	var out strings.Builder
+	Format(&out, aggregates)
+	if want := "{Berlin=-1.0/-1.0/-1.0, Hamburg=-3.4/4.3/12.0}\n"; out.String() != want {
+		t.Errorf("Expected %s, got %s", want, out.String())
+	}
+}
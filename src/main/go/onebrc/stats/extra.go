@@ -0,0 +1,149 @@
+package stats
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Extra holds statistics beyond min/mean/max that are only collected on
+// demand as they make aggregation slower, see Fields.Extended.
+type Extra struct {
+	// SumSquares and SumSquaresHigh are the low and the high 64 bits of
+	// the sum of squared temperatures in hundredths of a degree squared.
+	SumSquares, SumSquaresHigh uint64
+
+	Sketch Sketch
+}
+
+// Add adds a single temperature in tenths of a degree.
+func (e *Extra) Add(temp int64) {
+	var carry uint64
+	e.SumSquares, carry = bits.Add64(e.SumSquares, uint64(temp*temp), 0)
+	e.SumSquaresHigh += carry
+	e.Sketch.Add(temp)
+}
+
+// Merge adds all temperatures of b.
+func (e *Extra) Merge(b *Extra) {
+	var carry uint64
+	e.SumSquares, carry = bits.Add64(e.SumSquares, b.SumSquares, 0)
+	e.SumSquaresHigh += b.SumSquaresHigh + carry
+	e.Sketch.Merge(&b.Sketch)
+}
+
+// Variance returns the population variance of temperatures of a in degrees
+// squared or NaN if a does not collect extra statistics.
+// It is computed exactly as (count*sumSquares - sum^2) / count^2 and rounded
+// to float64 only in the end.
+func (a *Aggregate) Variance() float64 {
+	if a.Extra == nil || a.Count == 0 {
+		return math.NaN()
+	}
+
+	sumSquares := new(big.Int).SetUint64(a.Extra.SumSquaresHigh)
+	sumSquares.Lsh(sumSquares, 64).Add(sumSquares, new(big.Int).SetUint64(a.Extra.SumSquares))
+
+	count := new(big.Int).SetUint64(a.Count)
+	sum := a.BigSum()
+
+	num := sumSquares.Mul(sumSquares, count)
+	num.Sub(num, sum.Mul(sum, sum))
+	den := count.Mul(count, count)
+	den.Mul(den, big.NewInt(100)) // tenths squared to degrees squared
+
+	v, _ := new(big.Rat).SetFrac(num, den).Float64()
+	return v
+}
+
+// StdDev returns the population standard deviation of temperatures of a in
+// degrees or NaN if a does not collect extra statistics.
+func (a *Aggregate) StdDev() float64 {
+	return math.Sqrt(a.Variance())
+}
+
+// Quantile returns the q-quantile of temperatures of a in degrees, e.g. 0.99
+// for the 99th percentile. It is exact if a has a histogram, approximate
+// if a collects extra statistics and NaN otherwise.
+func (a *Aggregate) Quantile(q float64) float64 {
+	if a.Count == 0 {
+		return math.NaN()
+	}
+	if a.Histogram != nil {
+		return float64(a.Histogram.Quantile(q)) / 10
+	}
+	if a.Extra == nil {
+		return math.NaN()
+	}
+	t := a.Extra.Sketch.Quantile(q)
+	return float64(min(max(a.Min, t), a.Max)) / 10
+}
+
+// Mode returns the most frequent temperature of a in degrees, the lowest one
+// if there are several, or NaN if a does not have a histogram.
+func (a *Aggregate) Mode() float64 {
+	if a.Histogram == nil || a.Count == 0 {
+		return math.NaN()
+	}
+	return float64(a.Histogram.Mode()) / 10
+}
+
+const (
+	sketchBucketWidth = 10 // tenths of a degree
+	sketchOrigin      = -1000
+	sketchBuckets     = (MaxTemperature-sketchOrigin)/sketchBucketWidth + 1
+)
+
+// Sketch is a mergeable histogram of temperatures with one degree buckets,
+// i.e. [-100.0, -99.1], ..., [-1.0, -0.1], [0.0, 0.9], ..., [99.0, 99.9].
+// Quantiles are interpolated within a bucket, so they are accurate within
+// one degree regardless of the number of temperatures.
+//
+// Temperatures must be within [MinTemperature, MaxTemperature].
+type Sketch struct {
+	Counts [sketchBuckets]uint64
+}
+
+func sketchBucket(temp int64) int {
+	return int(temp-sketchOrigin) / sketchBucketWidth
+}
+
+// Add adds a single temperature in tenths of a degree.
+func (s *Sketch) Add(temp int64) {
+	s.Counts[sketchBucket(temp)]++
+}
+
+// Merge adds all temperatures of b.
+func (s *Sketch) Merge(b *Sketch) {
+	for i, c := range b.Counts {
+		s.Counts[i] += c
+	}
+}
+
+// Quantile returns the approximate q-quantile in tenths of a degree using
+// the nearest rank method, or 0 if the sketch is empty.
+func (s *Sketch) Quantile(q float64) int64 {
+	var total uint64
+	for _, c := range s.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(min(max(q, 0), 1) * float64(total)))
+	rank = min(max(rank, 1), total)
+
+	var seen uint64
+	for i, c := range s.Counts {
+		if seen+c < rank {
+			seen += c
+			continue
+		}
+		// assume temperatures are evenly spread within the bucket
+		low := float64(sketchOrigin + i*sketchBucketWidth)
+		t := low - 0.5 + sketchBucketWidth*(float64(rank-seen)-0.5)/float64(c)
+		return int64(RoundJava(t))
+	}
+	panic("unreachable")
+}
@@ -0,0 +1,176 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Field is a statistic printed for each station.
+type Field struct {
+	// Name is one of min, mean, max, count, variance, stddev, mode, median or
+	// a percentile p<N>, e.g. p50, p99 or p99.9.
+	Name string
+
+	// Quantile of a percentile field, e.g. 0.99 for p99 and 0.5 for median.
+	Quantile float64
+}
+
+// Fields are statistics printed for each station separated by '/'.
+// Fields implements flag.Value so that it can be set by a comma separated
+// list, e.g. -stats=min,mean,max,stddev,p99.
+type Fields []Field
+
+// FieldsUsage is the usage of a flag that sets Fields, e.g.:
+//
+//	flag.Var(&fields, "stats", stats.FieldsUsage)
+const FieldsUsage = "comma separated `statistics` to print: min, mean, max, count, variance, stddev, mode, median and percentiles, e.g. p99"
+
+// DefaultFields are min/mean/max as expected by the challenge.
+var DefaultFields = Fields{{Name: "min"}, {Name: "mean"}, {Name: "max"}}
+
+// ParseFields parses a comma separated list of fields.
+func ParseFields(s string) (Fields, error) {
+	var fields Fields
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "min", "mean", "max", "count", "variance", "stddev", "mode":
+			fields = append(fields, Field{Name: name})
+		case "median":
+			fields = append(fields, Field{Name: name, Quantile: 0.5})
+		default:
+			p, err := strconv.ParseFloat(strings.TrimPrefix(name, "p"), 64)
+			if !strings.HasPrefix(name, "p") || err != nil || !(p >= 0 && p <= 100) {
+				return nil, fmt.Errorf("unknown statistic %q, expected min, mean, max, count, variance, stddev, mode, median or p<0-100>", name)
+			}
+			fields = append(fields, Field{Name: name, Quantile: p / 100})
+		}
+	}
+	return fields, nil
+}
+
+func (fs *Fields) String() string {
+	names := make([]string, len(*fs))
+	for i, f := range *fs {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func (fs *Fields) Set(s string) error {
+	fields, err := ParseFields(s)
+	if err != nil {
+		return err
+	}
+	*fs = fields
+	return nil
+}
+
+// Extended reports whether any of the fields needs extra statistics,
+// i.e. aggregates must be created by NewAggregate.
+func (fs Fields) Extended() bool {
+	for _, f := range fs {
+		switch f.Name {
+		case "min", "mean", "max", "count", "mode":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// Histogram reports whether any of the fields needs an exact histogram,
+// i.e. the mode. Percentiles are exact if aggregates have histograms.
+func (fs Fields) Histogram() bool {
+	for _, f := range fs {
+		if f.Name == "mode" {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAggregate returns an empty aggregate that collects extra statistics
+// and histogram if the fields need them.
+func (fs Fields) NewAggregate() *Aggregate {
+	a := &Aggregate{}
+	if fs.Extended() {
+		a.Extra = new(Extra)
+	}
+	if fs.Histogram() {
+		a.Histogram = new(Histogram)
+	}
+	return a
+}
+
+// AppendText appends fields of the aggregate separated by '/' to buf.
+func (fs Fields) AppendText(buf []byte, a *Aggregate) []byte {
+	for i, f := range fs {
+		if i > 0 {
+			buf = append(buf, '/')
+		}
+		buf = f.AppendText(buf, a)
+	}
+	return buf
+}
+
+// AppendText appends the field of the aggregate to buf, temperatures are
+// formatted with one decimal place and missing statistics as NaN.
+func (f Field) AppendText(buf []byte, a *Aggregate) []byte {
+	switch f.Name {
+	case "min":
+		return AppendTenths(buf, a.Min)
+	case "mean":
+		return AppendTenths(buf, a.MeanTenths())
+	case "max":
+		return AppendTenths(buf, a.Max)
+	case "count":
+		return strconv.AppendUint(buf, a.Count, 10)
+	}
+	return strconv.AppendFloat(buf, f.Value(a), 'f', 1, 64)
+}
+
+// Value returns the field of the aggregate, e.g. a temperature in degrees,
+// or NaN if the aggregate does not collect the statistics needed.
+func (f Field) Value(a *Aggregate) float64 {
+	switch f.Name {
+	case "min":
+		return float64(a.Min) / 10
+	case "mean":
+		return a.Mean()
+	case "max":
+		return float64(a.Max) / 10
+	case "count":
+		return float64(a.Count)
+	case "variance":
+		return a.Variance()
+	case "stddev":
+		return a.StdDev()
+	case "mode":
+		return a.Mode()
+	default:
+		return a.Quantile(f.Quantile)
+	}
+}
+
+// FormatFields is like Format but writes the given fields of each station,
+// e.g. {Abha=-23.0/18.0/59.2/9.1, ...} for min,mean,max,stddev.
+func FormatFields(w io.Writer, aggregates map[string]*Aggregate, fields Fields) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	bw.WriteByte('{')
+	for i, name := range SortedNames(aggregates) {
+		if i > 0 {
+			bw.WriteString(", ")
+		}
+		bw.WriteString(name)
+		bw.WriteByte('=')
+		buf = fields.AppendText(buf[:0], aggregates[name])
+		bw.Write(buf)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
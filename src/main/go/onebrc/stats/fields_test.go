@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("min,mean,max,count,variance,stddev,p0,p50,p99.9,p100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := fields.String(); s != "min,mean,max,count,variance,stddev,p0,p50,p99.9,p100" {
+		t.Errorf("Wrong fields: %s", s)
+	}
+	if q := fields[8].Quantile; math.Abs(q-0.999) > 1e-9 {
+		t.Errorf("Wrong quantile of p99.9, expected: 0.999, got: %v", q)
+	}
+	if !fields.Extended() || DefaultFields.Extended() {
+		t.Errorf("Wrong Extended")
+	}
+
+	for _, s := range []string{"", "avg", "p", "p101", "p-1", "pNaN", "min,,max"} {
+		if _, err := ParseFields(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestFormatFields(t *testing.T) {
+	fields, err := ParseFields("min,mean,max,count,variance,stddev,p50,p99")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aggregates := make(map[string]*Aggregate)
+	for _, r := range []struct {
+		name string
+		temp int64
+	}{{"a", 20}, {"a", 40}, {"a", 40}, {"a", 40}, {"a", 50}, {"a", 50}, {"a", 70}, {"a", 90}, {"b", -123}} {
+		if aggregates[r.name] == nil {
+			aggregates[r.name] = fields.NewAggregate()
+		}
+		aggregates[r.name].Add(r.temp)
+	}
+
+	var out strings.Builder
+	if err := FormatFields(&out, aggregates, fields); err != nil {
+		t.Fatal(err)
+	}
+	const expected = "{a=2.0/5.0/9.0/8/4.0/2.0/4.8/9.0, b=-12.3/-12.3/-12.3/1/0.0/0.0/-12.3/-12.3}\n"
+	if out.String() != expected {
+		t.Errorf("Wrong output, expected: %s, got: %s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := Format(&out, aggregates); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "{a=2.0/5.0/9.0, b=-12.3/-12.3/-12.3}\n" {
+		t.Errorf("Wrong default output: %s", out.String())
+	}
+}
+
+func TestExtra(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	all := &Aggregate{Extra: new(Extra)}
+	chunks := make([]*Aggregate, 4)
+	for i := range chunks {
+		chunks[i] = &Aggregate{Extra: new(Extra)}
+	}
+	temps := make([]int64, 100_000)
+	for i := range temps {
+		temps[i] = min(max(int64(rnd.NormFloat64()*100+150), MinTemperature), MaxTemperature)
+		all.Add(temps[i])
+		chunks[rnd.Intn(len(chunks))].Add(temps[i])
+	}
+
+	merged := &Aggregate{}
+	for _, c := range chunks {
+		merged.Merge(c)
+	}
+	if *merged.Extra != *all.Extra || merged.String() != all.String() {
+		t.Errorf("Merged aggregate differs")
+	}
+	if *chunks[0].Extra == *merged.Extra {
+		t.Errorf("Merged aggregate shares extra statistics")
+	}
+
+	if sd := all.StdDev(); math.Abs(sd-10) > 0.1 {
+		t.Errorf("Wrong stddev, expected about 10, got: %v", sd)
+	}
+
+	sort.Slice(temps, func(i, j int) bool { return temps[i] < temps[j] })
+	for _, q := range []float64{0, 0.01, 0.1, 0.5, 0.9, 0.99, 0.999, 1} {
+		rank := max(int(math.Ceil(q*float64(len(temps)))), 1)
+		expected := float64(temps[rank-1]) / 10
+		if actual := all.Quantile(q); math.Abs(actual-expected) > 1 {
+			t.Errorf("Wrong %v quantile, expected: %v, got: %v", q, expected, actual)
+		}
+	}
+
+	if !math.IsNaN((&Aggregate{Count: 1}).StdDev()) {
+		t.Errorf("Expected NaN stddev without extra statistics")
+	}
+}
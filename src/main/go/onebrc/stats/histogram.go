@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Histogram counts each of the 1999 possible temperatures exactly, so that
+// quantiles and mode are exact at the cost of 16 KB per station.
+//
+// Temperatures must be within [MinTemperature, MaxTemperature].
+type Histogram struct {
+	Counts [MaxTemperature - MinTemperature + 1]uint64
+}
+
+// Add adds a single temperature in tenths of a degree.
+func (h *Histogram) Add(temp int64) {
+	h.Counts[temp-MinTemperature]++
+}
+
+// Merge adds all temperatures of b.
+func (h *Histogram) Merge(b *Histogram) {
+	for i, c := range b.Counts {
+		h.Counts[i] += c
+	}
+}
+
+// Quantile returns the q-quantile in tenths of a degree using the nearest
+// rank method, or 0 if the histogram is empty.
+func (h *Histogram) Quantile(q float64) int64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(min(max(q, 0), 1) * float64(total)))
+	rank = min(max(rank, 1), total)
+
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			return int64(i) + MinTemperature
+		}
+	}
+	panic("unreachable")
+}
+
+// Mode returns the most frequent temperature in tenths of a degree,
+// the lowest one if there are several, or 0 if the histogram is empty.
+func (h *Histogram) Mode() int64 {
+	mode := 0
+	for i, c := range h.Counts {
+		if c > h.Counts[mode] {
+			mode = i
+		}
+	}
+	if h.Counts[mode] == 0 {
+		return 0
+	}
+	return int64(mode) + MinTemperature
+}
+
+// WriteHistogramsJSON writes non-empty histogram buckets of aggregates as
+// a JSON object sorted by station name, e.g. {"Abha":{"-1.2":3,"18.0":5}},
+// followed by a new line. Aggregates without a histogram are written as null.
+func WriteHistogramsJSON(w io.Writer, aggregates map[string]*Aggregate) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	bw.WriteByte('{')
+	for i, name := range SortedNames(aggregates) {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		bw.Write(key)
+		bw.WriteByte(':')
+
+		h := aggregates[name].Histogram
+		if h == nil {
+			bw.WriteString("null")
+			continue
+		}
+		buf = append(buf[:0], '{')
+		for t, c := range h.Counts {
+			if c == 0 {
+				continue
+			}
+			if len(buf) > 1 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, '"')
+			buf = AppendTenths(buf, int64(t)+MinTemperature)
+			buf = append(buf, '"', ':')
+			buf = strconv.AppendUint(buf, c, 10)
+		}
+		buf = append(buf, '}')
+		bw.Write(buf)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// WriteHistogramsCSV writes non-empty histogram buckets of aggregates as CSV
+// with station,temperature,count header, sorted by station name and temperature.
+// Aggregates without a histogram are skipped.
+func WriteHistogramsCSV(w io.Writer, aggregates map[string]*Aggregate) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"station", "temperature", "count"})
+	for _, name := range SortedNames(aggregates) {
+		h := aggregates[name].Histogram
+		if h == nil {
+			continue
+		}
+		for t, c := range h.Counts {
+			if c == 0 {
+				continue
+			}
+			cw.Write([]string{name, string(AppendTenths(nil, int64(t)+MinTemperature)), strconv.FormatUint(c, 10)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,98 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	var all, merged Histogram
+	chunks := make([]Histogram, 3)
+	temps := make([]int64, 10_000)
+	for i := range temps {
+		temps[i] = min(max(int64(rnd.NormFloat64()*50), MinTemperature), MaxTemperature)
+		all.Add(temps[i])
+		chunks[rnd.Intn(len(chunks))].Add(temps[i])
+	}
+	for i := range chunks {
+		merged.Merge(&chunks[i])
+	}
+	if merged != all {
+		t.Errorf("Merged histogram differs")
+	}
+
+	counts := make(map[int64]int)
+	for _, temp := range temps {
+		counts[temp]++
+	}
+	var mode int64
+	for temp, c := range counts {
+		if c > counts[mode] || c == counts[mode] && temp < mode {
+			mode = temp
+		}
+	}
+	if actual := all.Mode(); actual != mode {
+		t.Errorf("Wrong mode, expected: %d, got: %d", mode, actual)
+	}
+
+	sort.Slice(temps, func(i, j int) bool { return temps[i] < temps[j] })
+	for _, q := range []float64{0, 0.0001, 0.01, 0.5, 0.9, 0.99, 0.9999, 1} {
+		rank := max(int(math.Ceil(q*float64(len(temps)))), 1)
+		if actual := all.Quantile(q); actual != temps[rank-1] {
+			t.Errorf("Wrong %v quantile, expected: %d, got: %d", q, temps[rank-1], actual)
+		}
+	}
+
+	var empty Histogram
+	if empty.Quantile(0.5) != 0 || empty.Mode() != 0 {
+		t.Errorf("Expected zero quantile and mode of empty histogram")
+	}
+}
+
+func TestWriteHistograms(t *testing.T) {
+	fields, err := ParseFields("min,median,mode,max")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aggregates := make(map[string]*Aggregate)
+	for _, r := range []struct {
+		name string
+		temp int64
+	}{{"a", 12}, {"a", -999}, {"a", 12}, {"a", 999}, {`b"`, 0}} {
+		if aggregates[r.name] == nil {
+			aggregates[r.name] = fields.NewAggregate()
+		}
+		aggregates[r.name].Add(r.temp)
+	}
+	aggregates["c"] = &Aggregate{Min: 1, Max: 1, Sum: 1, Count: 1}
+
+	var out strings.Builder
+	if err := FormatFields(&out, aggregates, fields); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{a=-99.9/1.2/1.2/99.9, b\"=0.0/0.0/0.0/0.0, c=0.1/NaN/NaN/0.1}\n"; out.String() != expected {
+		t.Errorf("Wrong output, expected: %s, got: %s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := WriteHistogramsJSON(&out, aggregates); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"a":{"-99.9":1,"1.2":2,"99.9":1},"b\"":{"0.0":1},"c":null}` + "\n"; out.String() != expected {
+		t.Errorf("Wrong JSON, expected: %s, got: %s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := WriteHistogramsCSV(&out, aggregates); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "station,temperature,count\na,-99.9,1\na,1.2,2\na,99.9,1\n\"b\"\"\",0.0,1\n"; out.String() != expected {
+		t.Errorf("Wrong CSV, expected: %s, got: %s", expected, out.String())
+	}
+}
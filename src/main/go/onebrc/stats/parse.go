@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Result is a station result as formatted by Format.
+type Result struct {
+	Name           string
+	Min, Mean, Max float64
+}
+
+// String returns the result formatted as name=min/mean/max.
+func (r Result) String() string {
+	return fmt.Sprintf("%s=%.1f/%.1f/%.1f", r.Name, r.Min, r.Mean, r.Max)
+}
+
+// ParseResults parses output of Format, e.g. {a=1.0/2.0/3.0, b=4.0/5.0/6.0},
+// and returns results in the output order.
+// Station names may contain ", " so, like tocsv.sh, the output is split on
+// ", " only if it is preceded by a digit.
+func ParseResults(data []byte) ([]Result, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != '{' || data[len(data)-1] != '}' {
+		return nil, fmt.Errorf("output is not enclosed in braces: %.40q", data)
+	}
+	data = data[1 : len(data)-1]
+
+	var results []Result
+	for len(data) > 0 {
+		end := len(data)
+		for i := 0; i+2 < len(data); i++ {
+			if data[i+1] == ',' && data[i+2] == ' ' && data[i] >= '0' && data[i] <= '9' {
+				end = i + 1
+				break
+			}
+		}
+
+		r, err := parseResult(data[:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+
+		data = data[min(end+2, len(data)):]
+	}
+	return results, nil
+}
+
+func parseResult(data []byte) (Result, error) {
+	eqPos := bytes.LastIndexByte(data, '=')
+	if eqPos == -1 {
+		return Result{}, fmt.Errorf("missing '=': %q", data)
+	}
+
+	values := bytes.Split(data[eqPos+1:], []byte{'/'})
+	if len(values) != 3 {
+		return Result{}, fmt.Errorf("expected min/mean/max: %q", data)
+	}
+
+	var parsed [3]float64
+	for i, v := range values {
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("invalid value %q: %q", v, data)
+		}
+		parsed[i] = f
+	}
+	return Result{Name: string(data[:eqPos]), Min: parsed[0], Mean: parsed[1], Max: parsed[2]}, nil
+}
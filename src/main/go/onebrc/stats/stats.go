@@ -0,0 +1,274 @@
+// Package stats aggregates temperature measurements of weather stations
+// given as "<station name>;<temperature>\n" records and formats the results
+// as expected by the challenge, i.e. {a=min/mean/max, b=min/mean/max, ...}.
+//
+// Temperatures are kept as integers in tenths of a degree, e.g. 12.3 is 123.
+package stats
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// Aggregate holds statistics of a single station, in tenths of a degree.
+// The zero value is an empty aggregate ready to use.
+//
+// The sum is kept in 128 bits as SumHigh*2^64 + Sum, so that it does not
+// overflow for any number of measurements that fits into Count. Add and Merge
+// carry into SumHigh. Solvers that update Sum directly in their hot loop must
+// only do so while it can not overflow, e.g. within a chunk of input that is
+// smaller than 2^63/999 records, and use Merge to combine chunks.
+//
+// Extra and Histogram are nil unless extra statistics or exact histograms
+// are collected, see Fields.NewAggregate. Aggregates that are merged must
+// either all collect them or none.
+type Aggregate struct {
+	Min, Max     int64
+	Sum, SumHigh int64
+	Count        uint64
+	Extra        *Extra
+	Histogram    *Histogram
+}
+
+// Add adds a single temperature to the aggregate.
+func (a *Aggregate) Add(temp int64) {
+	if a.Count == 0 {
+		a.Min = temp
+		a.Max = temp
+	} else {
+		a.Min = min(a.Min, temp)
+		a.Max = max(a.Max, temp)
+	}
+	a.addSum(temp)
+	a.Count++
+	if a.Extra != nil {
+		a.Extra.Add(temp)
+	}
+	if a.Histogram != nil {
+		a.Histogram.Add(temp)
+	}
+}
+
+// Merge adds all temperatures of b to the aggregate.
+// It panics if the count overflows uint64.
+func (a *Aggregate) Merge(b *Aggregate) {
+	if b.Count == 0 {
+		return
+	}
+	if a.Count == 0 {
+		extra, histogram := a.Extra, a.Histogram
+		*a = *b
+		// do not share
+		a.Extra, a.Histogram = extra, histogram
+		if b.Extra != nil {
+			if a.Extra == nil {
+				a.Extra = new(Extra)
+			}
+			*a.Extra = *b.Extra
+		}
+		if b.Histogram != nil {
+			if a.Histogram == nil {
+				a.Histogram = new(Histogram)
+			}
+			*a.Histogram = *b.Histogram
+		}
+		return
+	}
+	count, carry := bits.Add64(a.Count, b.Count, 0)
+	if carry != 0 {
+		panic("stats: count overflows uint64")
+	}
+	a.Min = min(a.Min, b.Min)
+	a.Max = max(a.Max, b.Max)
+	a.addSum(b.Sum)
+	a.SumHigh += b.SumHigh
+	a.Count = count
+	if b.Extra != nil {
+		if a.Extra == nil {
+			a.Extra = new(Extra)
+		}
+		a.Extra.Merge(b.Extra)
+	}
+	if b.Histogram != nil {
+		if a.Histogram == nil {
+			a.Histogram = new(Histogram)
+		}
+		a.Histogram.Merge(b.Histogram)
+	}
+}
+
+// addSum adds v to the sum and carries overflow of the low 64 bits.
+func (a *Aggregate) addSum(v int64) {
+	s := a.Sum + v
+	if v >= 0 && s < a.Sum {
+		a.SumHigh++
+	} else if v < 0 && s > a.Sum {
+		a.SumHigh--
+	}
+	a.Sum = s
+}
+
+// BigSum returns the sum of temperatures in tenths of a degree.
+func (a *Aggregate) BigSum() *big.Int {
+	sum := big.NewInt(a.SumHigh)
+	sum.Lsh(sum, 64)
+	return sum.Add(sum, big.NewInt(a.Sum))
+}
+
+// Mean returns the mean temperature in degrees rounded to one decimal place.
+func (a *Aggregate) Mean() float64 {
+	return float64(a.MeanTenths()) / 10.0
+}
+
+// MeanTenths returns the mean temperature in tenths of a degree rounded with
+// ties toward positive infinity like java's Math.round. Unlike rounding of
+// a float64 mean it is exact.
+func (a *Aggregate) MeanTenths() int64 {
+	const limit = 1 << 61 // 2*Sum+Count does not overflow int64
+	if a.SumHigh == 0 && -limit < a.Sum && a.Sum < limit && a.Count < limit {
+		count := int64(a.Count)
+		return FloorDiv(2*a.Sum+count, 2*count)
+	}
+
+	// (2*sum + count) / (2*count), big.Int.Div rounds toward negative
+	// infinity for a positive divisor
+	count := new(big.Int).SetUint64(a.Count)
+	sum := a.BigSum()
+	sum.Lsh(sum, 1).Add(sum, count)
+	return sum.Div(sum, count.Lsh(count, 1)).Int64()
+}
+
+// String returns the aggregate formatted as min/mean/max in degrees.
+func (a *Aggregate) String() string {
+	return string(a.AppendText(nil))
+}
+
+// AppendText appends the aggregate formatted as min/mean/max in degrees to buf.
+func (a *Aggregate) AppendText(buf []byte) []byte {
+	buf = AppendTenths(buf, a.Min)
+	buf = append(buf, '/')
+	buf = AppendTenths(buf, a.MeanTenths())
+	buf = append(buf, '/')
+	return AppendTenths(buf, a.Max)
+}
+
+// AppendTenths appends t tenths of a degree formatted with one decimal place,
+// e.g. -123 as -12.3, to buf.
+func AppendTenths(buf []byte, t int64) []byte {
+	u := uint64(t)
+	if t < 0 {
+		buf = append(buf, '-')
+		u = -u
+	}
+	buf = strconv.AppendUint(buf, u/10, 10)
+	return append(buf, '.', byte('0'+u%10))
+}
+
+// FloorDiv returns a/b rounded toward negative infinity, unlike a/b that
+// truncates toward zero.
+func FloorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Merge merges aggregates of src into dst.
+// Aggregates of stations missing in dst are not copied but shared with src.
+func Merge(dst, src map[string]*Aggregate) {
+	for name, sa := range src {
+		if da := dst[name]; da == nil {
+			dst[name] = sa
+		} else {
+			da.Merge(sa)
+		}
+	}
+}
+
+// SortedNames returns station names of the aggregates in ascending order.
+func SortedNames(aggregates map[string]*Aggregate) []string {
+	names := make([]string, 0, len(aggregates))
+	for name := range aggregates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Format writes aggregates sorted by station name in the challenge format,
+// e.g. {Abha=-23.0/18.0/59.2, Abidjan=-16.2/26.0/67.3}, followed by a new line.
+func Format(w io.Writer, aggregates map[string]*Aggregate) error {
+	return FormatFields(w, aggregates, DefaultFields)
+}
+
+// Chunks splits data into chunks of at least size bytes, each extended up to
+// and including the next new line so that no record is split between chunks.
+// The last chunk ends at the end of data. Chunks are not copied.
+func Chunks(data []byte, size int) [][]byte {
+	size = max(size, 1)
+
+	chunks := make([][]byte, 0, len(data)/size+1)
+	for len(data) > 0 {
+		end := min(size, len(data))
+		if nlPos := bytes.IndexByte(data[end:], '\n'); nlPos == -1 {
+			end = len(data)
+		} else {
+			end += nlPos + 1
+		}
+
+		chunks = append(chunks, data[:end])
+		data = data[end:]
+	}
+	return chunks
+}
+
+// ParseTemperature reads decimal number that matches "^-?[0-9]{1,2}[.][0-9]" pattern,
+// e.g.: -12.3, -3.4, 5.6, 78.9 and return the value*10, i.e. -123, -34, 56, 789.
+func ParseTemperature(data []byte) int64 {
+	negative := data[0] == '-'
+	if negative {
+		data = data[1:]
+	}
+
+	var result int64
+	switch len(data) {
+	// 1.2
+	case 3:
+		result = int64(data[0])*10 + int64(data[2]) - '0'*(10+1)
+	// 12.3
+	case 4:
+		result = int64(data[0])*100 + int64(data[1])*10 + int64(data[3]) - '0'*(100+10+1)
+	}
+
+	if negative {
+		return -result
+	}
+	return result
+}
+
+// Round rounds x to one decimal place, see RoundJava.
+func Round(x float64) float64 {
+	return RoundJava(x*10.0) / 10.0
+}
+
+// RoundJava returns the closest integer to the argument, with ties
+// rounding to positive infinity, see java's Math.round
+func RoundJava(x float64) float64 {
+	t := math.Trunc(x)
+	if x < 0.0 && t-x == 0.5 {
+		// tie of a negative number, already rounded towards positive infinity
+	} else if math.Abs(x-t) >= 0.5 {
+		t += math.Copysign(1, x)
+	}
+
+	if t == 0 { // check -0
+		return 0.0
+	}
+	return t
+}
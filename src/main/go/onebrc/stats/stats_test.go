@@ -0,0 +1,231 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRoundJava(t *testing.T) {
+	for _, tc := range []struct {
+		value    float64
+		expected string
+	}{
+		{value: -1.5, expected: "-1.0"},
+		{value: -1.0, expected: "-1.0"},
+		{value: -0.7, expected: "-1.0"},
+		{value: -0.5, expected: "0.0"},
+		{value: -0.3, expected: "0.0"},
+		{value: 0.0, expected: "0.0"},
+		{value: 0.3, expected: "0.0"},
+		{value: 0.5, expected: "1.0"},
+		{value: 0.7, expected: "1.0"},
+		{value: 1.0, expected: "1.0"},
+		{value: 1.5, expected: "2.0"},
+	} {
+		if rounded := RoundJava(tc.value); fmt.Sprintf("%.1f", rounded) != tc.expected {
+			t.Errorf("Wrong rounding of %v, expected: %s, got: %.1f", tc.value, tc.expected, rounded)
+		}
+	}
+}
+
+func TestParseTemperature(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected string
+	}{
+		{value: "-99.9", expected: "-999"},
+		{value: "-12.3", expected: "-123"},
+		{value: "-1.5", expected: "-15"},
+		{value: "-1.0", expected: "-10"},
+		{value: "0.0", expected: "0"},
+		{value: "0.3", expected: "3"},
+		{value: "12.3", expected: "123"},
+		{value: "99.9", expected: "999"},
+	} {
+		if number := ParseTemperature([]byte(tc.value)); fmt.Sprintf("%d", number) != tc.expected {
+			t.Errorf("Wrong parsing of %v, expected: %s, got: %d", tc.value, tc.expected, number)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	data := []byte("a\x00b;1.0\nc;-2.5\nlonger name;12.3\nd;0.0\n\x00;4.4\n")
+
+	for size := 0; size <= len(data)+1; size++ {
+		chunks := Chunks(data, size)
+		for _, chunk := range chunks {
+			if chunk[len(chunk)-1] != '\n' {
+				t.Errorf("Chunk %q does not end with a new line for size %d", chunk, size)
+			}
+		}
+		if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, data) {
+			t.Errorf("Wrong chunks for size %d, expected: %q, got: %q", size, data, joined)
+		}
+	}
+
+	if chunks := Chunks(nil, 10); len(chunks) != 0 {
+		t.Errorf("Expected no chunks for empty data, got: %q", chunks)
+	}
+}
+
+func TestAggregateString(t *testing.T) {
+	for _, tc := range []struct {
+		a        Aggregate
+		expected string
+	}{
+		{Aggregate{Min: 0, Max: 0, Sum: 0, Count: 1}, "0.0/0.0/0.0"},
+		{Aggregate{Min: -4, Max: 3, Sum: -1, Count: 3}, "-0.4/0.0/0.3"},
+		{Aggregate{Min: -997, Max: -996, Sum: -1993, Count: 2}, "-99.7/-99.6/-99.6"}, // -99.65
+		{Aggregate{Min: 996, Max: 997, Sum: 1993, Count: 2}, "99.6/99.7/99.7"},       // 99.65
+		{Aggregate{Min: -999, Max: 999, Sum: 1, Count: 3}, "-99.9/0.0/99.9"},
+		{Aggregate{Min: -999, Max: 999, Sum: -3, Count: 2}, "-99.9/-0.1/99.9"}, // -0.15
+		{Aggregate{Min: 1, Max: 1, Sum: 1_000_000_000_000_001, Count: 1_000_000_000_000_000}, "0.1/0.1/0.1"},
+	} {
+		if actual := tc.a.String(); actual != tc.expected {
+			t.Errorf("Wrong format of %+v, expected: %s, got: %s", tc.a, tc.expected, actual)
+		}
+	}
+}
+
+func TestAggregateOverflow(t *testing.T) {
+	// means of 99.85 and -99.85 are ties and round toward positive infinity
+	for _, tc := range []struct {
+		low, high int64
+		expected  string
+	}{
+		{998, 999, "99.8/99.9/99.9"},
+		{-999, -998, "-99.9/-99.8/-99.8"},
+	} {
+		// chunk aggregates with sums close to the int64 boundary
+		count := uint64(math.MaxInt64 / 999)
+		low := Aggregate{Min: tc.low, Max: tc.low, Sum: tc.low * int64(count), Count: count}
+		high := Aggregate{Min: tc.high, Max: tc.high, Sum: tc.high * int64(count), Count: count}
+
+		var a Aggregate
+		for i := 0; i < 3; i++ {
+			a.Merge(&low)
+			a.Merge(&high)
+		}
+		for i := 0; i < 2; i++ {
+			a.Add(tc.low)
+			a.Add(tc.high)
+		}
+
+		// (low + high) * (3*count + 2)
+		expectedSum := new(big.Int).SetUint64(3*count + 2)
+		expectedSum.Mul(expectedSum, big.NewInt(tc.low+tc.high))
+		if a.BigSum().Cmp(expectedSum) != 0 {
+			t.Errorf("Wrong sum, expected: %v, got: %v", expectedSum, a.BigSum())
+		}
+		if expectedCount := 6*count + 4; a.Count != expectedCount {
+			t.Errorf("Wrong count, expected: %d, got: %d", expectedCount, a.Count)
+		}
+		if actual := a.String(); actual != tc.expected {
+			t.Errorf("Wrong format, expected: %s, got: %s", tc.expected, actual)
+		}
+	}
+}
+
+func TestAggregateCountOverflow(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic")
+		}
+	}()
+
+	a := Aggregate{Count: math.MaxUint64}
+	a.Merge(&Aggregate{Count: 1})
+}
+
+func TestMergeAndFormat(t *testing.T) {
+	a := map[string]*Aggregate{}
+	for _, r := range []struct {
+		name string
+		temp int64
+	}{{"Hamburg", 120}, {"Bulawayo", 89}, {"Hamburg", -34}} {
+		if a[r.name] == nil {
+			a[r.name] = &Aggregate{}
+		}
+		a[r.name].Add(r.temp)
+	}
+
+	b := map[string]*Aggregate{
+		"Hamburg":    {Min: 5, Max: 5, Sum: 5, Count: 1},
+		"Palembang":  {Min: 388, Max: 388, Sum: 388, Count: 1},
+		"St. John's": {Min: 152, Max: 152, Sum: 152, Count: 1},
+		"Cracow":     {Min: 126, Max: 126, Sum: 126, Count: 1},
+		"Bridgetown": {Min: 269, Max: 269, Sum: 269, Count: 1},
+		"Istanbul":   {Min: 62, Max: 62, Sum: 62, Count: 1},
+		"Roseau":     {Min: 343, Max: 343, Sum: 343, Count: 1},
+		"Conakry":    {Min: 314, Max: 314, Sum: 314, Count: 1},
+	}
+
+	Merge(a, b)
+
+	var out strings.Builder
+	if err := Format(&out, a); err != nil {
+		t.Fatal(err)
+	}
+	const expected = "{Bridgetown=26.9/26.9/26.9, Bulawayo=8.9/8.9/8.9, Conakry=31.4/31.4/31.4, Cracow=12.6/12.6/12.6, " +
+		"Hamburg=-3.4/3.0/12.0, Istanbul=6.2/6.2/6.2, Palembang=38.8/38.8/38.8, Roseau=34.3/34.3/34.3, St. John's=15.2/15.2/15.2}\n"
+	if out.String() != expected {
+		t.Errorf("Wrong output, expected: %s, got: %s", expected, out.String())
+	}
+}
+
+var parseTemperatureSink int64
+
+func BenchmarkParseTemperature(b *testing.B) {
+	data1 := []byte("1.2")
+	data2 := []byte("-12.3")
+
+	for i := 0; i < b.N; i++ {
+		parseTemperatureSink = ParseTemperature(data1) + ParseTemperature(data2)
+	}
+}
+
+func FuzzParseTemperature(f *testing.F) {
+	for _, s := range []string{"-99.9", "-12.3", "-1.5", "0.0", "-0.0", "12.3", "99.9", "1.23", "x"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if !ValidTemperature([]byte(s)) {
+			t.Skip()
+		}
+
+		expected, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			t.Fatalf("Valid temperature %q is not a number: %v", s, err)
+		}
+		if got := ParseTemperature([]byte(s)); float64(got) != math.Round(expected*10) {
+			t.Errorf("Wrong parsing of %q, expected: %v, got: %d", s, math.Round(expected*10), got)
+		}
+	})
+}
+
+func FuzzChunks(f *testing.F) {
+	f.Add([]byte("a;1.0\nb;2.0\n"), 3)
+	f.Add([]byte("a;1.0\n\nb;2.0"), 0)
+	f.Fuzz(func(t *testing.T, data []byte, size int) {
+		chunks := Chunks(data, size)
+		for i, chunk := range chunks {
+			if len(chunk) == 0 {
+				t.Fatalf("Empty chunk %d", i)
+			}
+			if i < len(chunks)-1 && (chunk[len(chunk)-1] != '\n' || len(chunk) < size) {
+				t.Errorf("Chunk %d %q is shorter than %d or does not end with a new line", i, chunk, size)
+			}
+			if nlPos := bytes.IndexByte(chunk, '\n'); nlPos != -1 && nlPos < len(chunk)-1 && nlPos >= max(size, 1) {
+				t.Errorf("Chunk %d %q is extended past the first new line after %d bytes", i, chunk, size)
+			}
+		}
+		if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, data) {
+			t.Errorf("Chunks do not add up to data, expected: %q, got: %q", data, joined)
+		}
+	})
+}
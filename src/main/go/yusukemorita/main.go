@@ -1,68 +1,94 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"fmt"
+	"flag"
 	"io"
 	"log"
-	"math"
 	"os"
-	"runtime"
-	"runtime/pprof"
-	"slices"
-	"strings"
 	"sync"
-	"time"
-	"flag"
+	"syscall"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/groupby"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/profile"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
 )
 
-var attemptNumber = flag.String("attempt", "", "used for naming of .prof files")
-const concurrency = 4
-const batchSize = 100
+// go run main.go [flags] [measurements_file]
+// use "-" as measurements_file to read from stdin
 
-const chunkSize = 1 * 1024 * 1024 // 1mb
-// const chunkSize = 500 * 1024 // 500kb
+const defaultMeasurementsPath = "measurements.txt"
+
+var (
+	concurrency = flag.Int("concurrency", 4, "number of goroutines processing chunks")
+	batchSize   = flag.Int("batch-size", 100, "number of chunks buffered between the reader and the processors")
+	chunkSize   = flag.Int("chunk-size", 1*1024*1024, "size of each chunk in bytes") // 1mb
+	format      = flag.String("format", "canonical", `output format: "canonical" for {a=x/y/z, ...} or "lines" for one station per line`)
+	validate    = flag.Bool("validate", false, "report each malformed record and fail if there is any")
+	skipInvalid = flag.Bool("skip-invalid", false, "skip and count malformed records instead of assuming valid input")
+
+	// statistics to print, set by -stats flag
+	fields = stats.DefaultFields
+)
 
 func main() {
+	var profiles profile.Config
+	profiles.RegisterFlags(flag.CommandLine)
+	flag.Var(&fields, "stats", stats.FieldsUsage)
+	var groups groupby.Config
+	groups.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	cpuProfile := fmt.Sprintf("cpu%s.prof", *attemptNumber)
-	f, err := os.Create(cpuProfile)
-	if err != nil {
-		log.Fatal("could not create CPU profile: ", err)
+	measurementsPath := defaultMeasurementsPath
+	if flag.NArg() > 0 {
+		measurementsPath = flag.Arg(0)
 	}
-	defer f.Close() // error handling omitted for example
-	if err := pprof.StartCPUProfile(f); err != nil {
-		log.Fatal("could not start CPU profile: ", err)
+	if *concurrency < 1 || *batchSize < 0 || *chunkSize < 1 {
+		log.Fatalf("invalid flags: concurrency=%d batchSize=%d chunkSize=%d", *concurrency, *batchSize, *chunkSize)
+	}
+	if *format != "canonical" && *format != "lines" {
+		log.Fatalf("unknown format: %s", *format)
+	}
+	if err := groups.Load(); err != nil {
+		log.Fatalf("invalid group-by: %v", err)
 	}
-	defer pprof.StopCPUProfile()
-
-	startTime := time.Now()
 
-	run()
+	defer profiles.Start()()
 
-	fmt.Printf("\ntotal duration: %f seconds\n", time.Now().Sub(startTime).Seconds())
+	var allCities CityCollection
+	if *validate || *skipInvalid {
+		allCities = check(measurementsPath)
+	} else {
+		allCities = run(measurementsPath)
+	}
+	allCities.cities = groups.Apply(allCities.cities)
 
-	memoryProfile := fmt.Sprintf("memory%s.prof", *attemptNumber)
-	f, err = os.Create(memoryProfile)
-	if err != nil {
-		log.Fatal("could not create memory profile: ", err)
+	writer := bufio.NewWriter(os.Stdout)
+	if err := printResults(writer, allCities, *format); err != nil {
+		log.Fatal(err)
 	}
-	defer f.Close() // error handling omitted for example
-	runtime.GC()    // get up-to-date statistics
-	if err := pprof.WriteHeapProfile(f); err != nil {
-		log.Fatal("could not write memory profile: ", err)
+	if err := writer.Flush(); err != nil {
+		log.Fatal(err)
 	}
 }
 
-func run() {
+func run(measurementsPath string) CityCollection {
 	// read file
-	chunkChannel := make(chan string, 100)
-	cityCollectionChannel := make(chan CityCollection, concurrency)
-	go readFileInChunks(chunkChannel)
+	chunkChannel := make(chan []byte, *batchSize)
+	cityCollectionChannel := make(chan CityCollection, *concurrency)
+	if measurementsPath == "-" {
+		go readStreamInChunks(os.Stdin, chunkChannel)
+	} else {
+		// chunks reference the mapped file, so it must stay mapped until
+		// all of them are processed, i.e. until run returns
+		data, unmap := mmapFile(measurementsPath)
+		defer unmap()
+		go splitInChunks(data, chunkChannel)
+	}
 
 	waitGroup := new(sync.WaitGroup)
-	waitGroup.Add(concurrency)
+	waitGroup.Add(*concurrency)
 
 	// close city collection channel after receiving all city collections
 	go func() {
@@ -70,7 +96,7 @@ func run() {
 		waitGroup.Wait()
 	}()
 
-	for i := 1; i <= concurrency; i++ {
+	for i := 1; i <= *concurrency; i++ {
 		go func() {
 			defer waitGroup.Done()
 			cities := processChunk(chunkChannel)
@@ -83,197 +109,179 @@ func run() {
 		allCities = allCities.Merge(collection)
 	}
 
-	var cityNames []string
-	for cityName, _ := range allCities.cities {
-		cityNames = append(cityNames, cityName)
+	return allCities
+}
+
+// check reads the file without assuming valid input, see stats.Check.
+func check(measurementsPath string) CityCollection {
+	var r io.Reader = os.Stdin
+	if measurementsPath != "-" {
+		file, err := os.Open(measurementsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	invalid := 0
+	cities, err := stats.CheckFields(r, fields, func(record *stats.InvalidRecord) {
+		invalid++
+		if *validate {
+			log.Printf("%s: %v", measurementsPath, record)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
-	slices.Sort(cityNames)
 
-	for _, cityName := range cityNames {
-		city := allCities.cities[cityName]
-		mean := math.Ceil(float64(city.sum) / float64(city.count))
-		fmt.Printf("%s=%.1f/%.1f/%.1f\n", cityName, float64(city.min)/10, float64(mean/10), float64(city.max)/10)
+	if invalid > 0 {
+		if *validate {
+			log.Fatalf("found %d invalid records", invalid)
+		}
+		log.Printf("skipped %d invalid records", invalid)
+	}
+	return CityCollection{cities: cities}
+}
+
+// printResults writes the selected fields of cities sorted by name, either in
+// the challenge's {a=x/y/z, b=x/y/z} format or with one city per line.
+func printResults(w io.Writer, allCities CityCollection, format string) error {
+	if format != "lines" {
+		return stats.FormatFields(w, allCities.cities, fields)
+	}
+
+	var line []byte
+	for _, cityName := range stats.SortedNames(allCities.cities) {
+		line = append(append(line[:0], cityName...), '=')
+		line = append(fields.AppendText(line, allCities.cities[cityName]), '\n')
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func readFileInChunks(chunkChannel chan string) {
-	file, err := os.Open("../../../../data/measurements.txt")
+// mmapFile maps the whole file into memory and returns a function to unmap it.
+func mmapFile(path string) (data []byte, unmap func()) {
+	file, err := os.Open(path)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer file.Close()
 
-	// limit := 500 * 1024 * 1024
-	buffer := make([]byte, chunkSize)
-	finished := false
-	var readCount int64 = 0
+	info, err := file.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	for {
-		if finished {
-			break
+	size := info.Size()
+	if size == 0 {
+		return nil, func() {}
+	}
+	if size != int64(int(size)) {
+		log.Fatalf("file is too large to map: %d bytes", size)
+	}
+
+	data, err = syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return data, func() {
+		if err := syscall.Munmap(data); err != nil {
+			log.Fatal(err)
 		}
+	}
+}
 
-		count, err := file.ReadAt(buffer, readCount)
+// splitInChunks sends chunks of roughly chunkSize bytes that end on a new line.
+// Chunks are slices of data and are not copied.
+func splitInChunks(data []byte, chunkChannel chan []byte) {
+	for _, chunk := range stats.Chunks(data, *chunkSize) {
+		chunkChannel <- chunk
+	}
+	close(chunkChannel)
+}
+
+// readStreamInChunks is used for inputs that can not be mapped, e.g. stdin.
+// Each chunk is extended up to the next new line, same as splitInChunks.
+// The reader has a small buffer, so chunks are mostly read into directly
+// and the buffer is used to find the new line.
+func readStreamInChunks(r io.Reader, chunkChannel chan []byte) {
+	reader := bufio.NewReader(r)
+
+	for {
+		buffer := make([]byte, *chunkSize)
+		count, err := io.ReadFull(reader, buffer)
 		if err == io.EOF {
-			finished = true
-		} else if err != nil {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
 			log.Fatal(err)
 		}
-		readCount += int64(count)
+		buffer = buffer[:count]
 
 		// read up to the next new line
-		var extra []byte
-		for {
-			singleCharacterBuffer := make([]byte, 1)
-			_, err = file.ReadAt(singleCharacterBuffer, readCount)
-			if err == io.EOF {
-				finished = true
-				break
-			} else if err != nil {
+		if buffer[count-1] != '\n' {
+			extra, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
 				log.Fatal(err)
 			}
-			readCount++
-			extra = append(extra, singleCharacterBuffer...)
-			if singleCharacterBuffer[0] == '\n' {
-				break
-			}
+			buffer = append(buffer, extra...)
 		}
 
-		// remove null bytes from string, which can happen when
-		// the end of the file has been reached and the buffer is not full.
-		buffer = bytes.Trim(buffer, "\x00")
-		chunkChannel <- string(buffer) + string(extra)
-		buffer = make([]byte, chunkSize)
-
-		// if int(readCount) >= limit {
-		// 	break
-		// }
+		chunkChannel <- buffer
 	}
 	close(chunkChannel)
 }
 
-func processChunk(chunkChannel chan string) (cityCollection CityCollection) {
+func processChunk(chunkChannel chan []byte) (cityCollection CityCollection) {
 	cityCollection = NewCityCollection()
 
-	for linesString := range chunkChannel {
-		for {
-			line, remaining, found := strings.Cut(linesString, "\n")
-			if !found {
-				// end of string reached
-				break
+	for lines := range chunkChannel {
+		for len(lines) > 0 {
+			var line []byte
+			if newLine := bytes.IndexByte(lines, '\n'); newLine == -1 {
+				// the last line has no new line
+				line, lines = lines, nil
+			} else {
+				line = lines[:newLine]
+				lines = lines[newLine+1:]
 			}
-			linesString = remaining
+			line = bytes.TrimSuffix(line, []byte{'\r'})
 
-			cityName, temperaturesString, found := strings.Cut(line, ";")
-			if !found {
+			semicolon := bytes.IndexByte(line, ';')
+			if semicolon == -1 {
 				log.Fatalf("unexpected values: %s", line)
 			}
-			cityCollection.Add(cityName, parseTemperature(temperaturesString))
+			cityCollection.Add(line[:semicolon], stats.ParseTemperature(line[semicolon+1:]))
 		}
 	}
 
 	return cityCollection
 }
 
-// "41.1" -> 411
-// assume 3 digits if positive, and 4 digits if negative
-func parseTemperature(s string) int {
-	integerString, decimalString, found := strings.Cut(s, ".")
-	if !found {
-		log.Fatalf("dot not found: %s", s)
-	}
-
-	positive := integerString[0] != '-'
-	if !positive {
-		integerString = integerString[1:]
-	}
-
-	var integer int
-	if len(integerString) == 2 {
-		integer = convertTwoDigits(integerString)
-	} else {
-		integer = convertOneDigit(integerString[0])
-	}
-
-	decimal := convertOneDigit(decimalString[0])
-
-	absolute := integer*10 + decimal
-
-	if positive {
-		return absolute
-	} else {
-		return -1 * absolute
-	}
-}
-
-func convertTwoDigits(s string) int {
-	highDigit := convertOneDigit(s[0])
-	lowDigit := convertOneDigit(s[1])
-
-	return highDigit*10 + lowDigit
-}
-
-func convertOneDigit(b byte) int {
-	b -= '0'
-	return int(b)
-}
-
-type City struct {
-	min   int
-	max   int
-	sum   int
-	count int
-}
-
 type CityCollection struct {
-	cities map[string]*City
+	cities map[string]*stats.Aggregate
 }
 
 func (collection CityCollection) Merge(cc CityCollection) CityCollection {
-	new := cc.cities
-
-	for cityName, cityB := range collection.cities {
-		cityA, ok := new[cityName]
-		if ok {
-			if cityA.max < cityB.max {
-				cityA.max = cityB.max
-			}
-			if cityA.min > cityB.min {
-				cityA.min = cityB.min
-			}
-			cityA.count += cityB.count
-			cityA.sum += cityB.sum
-		} else {
-			new[cityName] = cityB
-		}
-	}
-
-	return CityCollection{cities: new}
+	stats.Merge(cc.cities, collection.cities)
+	return cc
 }
 
-func (collection CityCollection) Add(name string, temperature int) {
-	city, ok := collection.cities[name]
-	if ok {
-		if city.min > temperature {
-			city.min = temperature
-		}
-		if city.max < temperature {
-			city.max = temperature
-		}
-		city.sum += temperature
-		city.count++
-	} else {
-		collection.cities[name] = &City{
-			min:   temperature,
-			max:   temperature,
-			sum:   temperature,
-			count: 1,
-		}
+// Add does not retain name, so it may reference a reused or mapped buffer.
+func (collection CityCollection) Add(name []byte, temperature int64) {
+	city, ok := collection.cities[string(name)] // does not allocate
+	if !ok {
+		city = fields.NewAggregate()
+		collection.cities[string(name)] = city
 	}
+	city.Add(temperature)
 }
 
 func NewCityCollection() CityCollection {
 	return CityCollection{
-		cities: make(map[string]*City),
+		cities: make(map[string]*stats.Aggregate),
 	}
 }
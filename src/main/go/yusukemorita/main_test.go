@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/onebrctest"
+	"github.com/yusukemorita/1brc/src/main/go/onebrc/stats"
+)
+
+func TestSamples(t *testing.T) {
+	for _, size := range []int{*chunkSize, 64} {
+		defer func(size int) { *chunkSize = size }(*chunkSize)
+		*chunkSize = size
+
+		onebrctest.RunSamples(t, func(t *testing.T, path string) []byte {
+			var out bytes.Buffer
+			if err := printResults(&out, run(path), "canonical"); err != nil {
+				t.Fatal(err)
+			}
+			return out.Bytes()
+		})
+	}
+}
+
+func TestSplitInChunks(t *testing.T) {
+	data := []byte("a\x00b;1.0\nc;-2.5\nlonger name;12.3\nd;0.0\n\x00;4.4\n")
+
+	defer func(size int) { *chunkSize = size }(*chunkSize)
+	for size := 1; size <= len(data)+1; size++ {
+		*chunkSize = size
+
+		chunkChannel := make(chan []byte, len(data))
+		splitInChunks(data, chunkChannel)
+
+		var joined []byte
+		for chunk := range chunkChannel {
+			if chunk[len(chunk)-1] != '\n' {
+				t.Errorf("Chunk %q does not end with a new line for chunk size %d", chunk, size)
+			}
+			joined = append(joined, chunk...)
+		}
+		if !bytes.Equal(joined, data) {
+			t.Errorf("Wrong chunks for chunk size %d, expected: %q, got: %q", size, data, joined)
+		}
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	defer func(f stats.Fields) { fields = f }(fields)
+	var err error
+	fields, err = stats.ParseFields("min,mean,max,count,variance,stddev,p50,p90,p99")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := onebrctest.NewFixture(t)
+
+	var expected bytes.Buffer
+	if err := printResults(&expected, CityCollection{cities: fixture.Reference(t, fields.NewAggregate, nil)}, "lines"); err != nil {
+		t.Fatal(err)
+	}
+
+	var checked bytes.Buffer
+	if err := printResults(&checked, check(fixture.Path), "lines"); err != nil {
+		t.Fatal(err)
+	}
+	if checked.String() != expected.String() {
+		t.Errorf("Wrong result of check, expected: %s, got: %s", expected.String(), checked.String())
+	}
+
+	defer func(size int) { *chunkSize = size }(*chunkSize)
+	for _, size := range []int{100, 4096, len(fixture.Data)} {
+		*chunkSize = size
+
+		var out bytes.Buffer
+		if err := printResults(&out, run(fixture.Path), "lines"); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("Wrong result for chunk size %d, expected: %s, got: %s", size, expected.String(), out.String())
+		}
+	}
+}
+
+func TestReadStreamInChunks(t *testing.T) {
+	fixture := onebrctest.NewFixture(t)
+
+	defer func(size int) { *chunkSize = size }(*chunkSize)
+	for _, size := range []int{1, 100, 4096, 5000, len(fixture.Data)} {
+		*chunkSize = size
+
+		chunkChannel := make(chan []byte, 1)
+		go readStreamInChunks(iotest.HalfReader(bytes.NewReader(fixture.Data)), chunkChannel)
+
+		var read []byte
+		for chunk := range chunkChannel {
+			if len(chunk) < size && len(read)+len(chunk) < len(fixture.Data) || chunk[len(chunk)-1] != '\n' {
+				t.Fatalf("Chunk of size %d does not end on a new line after at least %d bytes: %q", size, size, chunk)
+			}
+			read = append(read, chunk...)
+		}
+		if !bytes.Equal(read, fixture.Data) {
+			t.Errorf("Chunks of size %d differ from the input", size)
+		}
+	}
+}
+
+func FuzzRun(f *testing.F) {
+	f.Add([]byte("seed data"), uint8(3), uint16(10))
+	f.Add([]byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b"), uint8(1), uint16(6))
+	f.Fuzz(func(t *testing.T, seed []byte, workers uint8, size uint16) {
+		data := onebrctest.Measurements(seed)
+
+		path := onebrctest.TempFile(t, data)
+
+		defer func(workers, size int) { *concurrency, *chunkSize = workers, size }(*concurrency, *chunkSize)
+		*concurrency, *chunkSize = int(workers%8)+1, int(size)+1
+
+		onebrctest.CompareAggregates(t, onebrctest.Reference(t, data), run(path).cities)
+	})
+}